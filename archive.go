@@ -0,0 +1,110 @@
+package clippingsfeed
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// IsArchive reports whether name is a vault archive OpenArchive can read: a .zip, .tar.gz or
+// .tgz file
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// OpenArchive reads the markdown notes of a vault archive, such as an exported backup,
+// without unpacking it. Notes are dated by the modification times of their entries.
+func OpenArchive(name string) (*MemFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var files map[string]MemFile
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		files, err = readZip(f)
+	} else {
+		files, err = readTarGz(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", name, err)
+	}
+
+	return NewMemFS(files), nil
+}
+
+func readZip(f *os.File) (map[string]MemFile, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]MemFile)
+	for _, entry := range r.File {
+		name := archivePath(entry.Name)
+		if entry.FileInfo().IsDir() || !IsMarkdownFile(name) {
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", entry.Name, err)
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name, err)
+		}
+		files[name] = MemFile{Data: data, ModTime: entry.Modified}
+	}
+
+	return files, nil
+}
+
+func readTarGz(f *os.File) (map[string]MemFile, error) {
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = gz.Close() }()
+
+	files := make(map[string]MemFile)
+	r := tar.NewReader(gz)
+	for {
+		header, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := archivePath(header.Name)
+		if header.Typeflag != tar.TypeReg || !IsMarkdownFile(name) {
+			continue
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		files[name] = MemFile{Data: data, ModTime: header.ModTime}
+	}
+}
+
+// archivePath turns the name of an archive entry into a path of the vault, dropping the
+// leading "./" or "/" some archivers add and the backslashes of Windows ones
+func archivePath(name string) string {
+	return strings.TrimLeft(path.Clean(strings.ReplaceAll(name, `\`, "/")), "/")
+}
@@ -0,0 +1,124 @@
+package clippingsfeed_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+type archiveEntry struct {
+	name    string
+	body    string
+	modTime time.Time
+}
+
+var archiveModTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var archiveEntries = []archiveEntry{
+	{name: "./Clippings/", modTime: archiveModTime},
+	{name: "./Clippings/undated.md", body: "---\ntitle: Undated\n---\nbody\n", modTime: archiveModTime},
+	{name: "./Clippings/dated.md", body: "---\ntitle: Dated\ncreated: 2023-05-01T00:00:00Z\n---\nbody\n", modTime: archiveModTime},
+	{name: "./Clippings/image.png", body: "not a note", modTime: archiveModTime},
+}
+
+func writeZip(t *testing.T, entries []archiveEntry) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "vault.zip")
+	f, err := os.Create(name)
+	assert.NilError(t, err)
+	defer func() { assert.NilError(t, f.Close()) }()
+
+	w := zip.NewWriter(f)
+	for _, entry := range entries {
+		e, err := w.CreateHeader(&zip.FileHeader{Name: entry.name, Modified: entry.modTime, Method: zip.Deflate})
+		assert.NilError(t, err)
+		_, err = e.Write([]byte(entry.body))
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, w.Close())
+	return name
+}
+
+func writeTarGz(t *testing.T, entries []archiveEntry) string {
+	t.Helper()
+
+	name := filepath.Join(t.TempDir(), "vault.tar.gz")
+	f, err := os.Create(name)
+	assert.NilError(t, err)
+	defer func() { assert.NilError(t, f.Close()) }()
+
+	gz := gzip.NewWriter(f)
+	w := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.body)), ModTime: entry.modTime, Typeflag: tar.TypeReg}
+		if entry.name[len(entry.name)-1] == '/' {
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		assert.NilError(t, w.WriteHeader(header))
+		_, err := w.Write([]byte(entry.body))
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, w.Close())
+	assert.NilError(t, gz.Close())
+	return name
+}
+
+func TestOpenArchive(t *testing.T) {
+	tests := map[string]func(*testing.T, []archiveEntry) string{
+		"zip":    writeZip,
+		"tar.gz": writeTarGz,
+	}
+
+	for name, write := range tests {
+		t.Run(name, func(t *testing.T) {
+			fsys, err := clippingsfeed.OpenArchive(write(t, archiveEntries))
+			assert.NilError(t, err)
+
+			_, err = fs.Stat(fsys, "Clippings/image.png")
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+
+			metadata, _, err := clippingsfeed.ScanVault(t.Context(), clippingsfeed.CreateParser(), fsys, clippingsfeed.WalkOptions{})
+			assert.NilError(t, err)
+
+			created := make(map[string]time.Time)
+			for _, m := range metadata {
+				created[m.Title] = m.Created
+			}
+			assert.Equal(t, len(created), 2)
+			assert.Assert(t, created["Undated"].Equal(archiveModTime), "created %v", created["Undated"])
+			assert.Assert(t, created["Dated"].Equal(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)), "created %v", created["Dated"])
+		})
+	}
+}
+
+func TestOpenArchiveErrors(t *testing.T) {
+	_, err := clippingsfeed.OpenArchive(filepath.Join(t.TempDir(), "missing.zip"))
+	assert.ErrorContains(t, err, "failed to open archive")
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.tar.gz")
+	assert.NilError(t, os.WriteFile(corrupt, []byte("not gzip"), 0644))
+	_, err = clippingsfeed.OpenArchive(corrupt)
+	assert.ErrorContains(t, err, "failed to read archive")
+}
+
+func TestIsArchive(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"backup.zip":    true,
+		"Backup.ZIP":    true,
+		"backup.tar.gz": true,
+		"backup.tgz":    true,
+		"backup.tar":    false,
+		"/vaults/home":  false,
+	} {
+		assert.Equal(t, clippingsfeed.IsArchive(name), expected, name)
+	}
+}
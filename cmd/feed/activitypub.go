@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	activityStreamsContext = "https://www.w3.org/ns/activitystreams"
+	securityContext        = "https://w3id.org/security/v1"
+	activityStreamsPublic  = "https://www.w3.org/ns/activitystreams#Public"
+	activityJSONType       = "application/activity+json"
+
+	// activityPubOutboxLimit is how many published clippings the outbox lists
+	activityPubOutboxLimit = 50
+	activityPubTimeout     = 10 * time.Second
+	activityPubAttempts    = 3
+	activityPubMaxBody     = 1 << 20
+)
+
+var activityPubUserPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+type apPublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type apEndpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+type apActor struct {
+	Context           any          `json:"@context,omitempty"`
+	ID                string       `json:"id"`
+	Type              string       `json:"type"`
+	PreferredUsername string       `json:"preferredUsername"`
+	Name              string       `json:"name,omitempty"`
+	Summary           string       `json:"summary,omitempty"`
+	URL               string       `json:"url,omitempty"`
+	Inbox             string       `json:"inbox"`
+	Outbox            string       `json:"outbox,omitempty"`
+	Followers         string       `json:"followers,omitempty"`
+	Endpoints         *apEndpoints `json:"endpoints,omitempty"`
+	PublicKey         apPublicKey  `json:"publicKey"`
+}
+
+// apObject is a published clipping, a Note linking the source or the Link to it
+type apObject struct {
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo"`
+	Name         string   `json:"name,omitempty"`
+	Content      string   `json:"content,omitempty"`
+	Href         string   `json:"href,omitempty"`
+	MediaType    string   `json:"mediaType,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Published    string   `json:"published"`
+	To           []string `json:"to"`
+	Cc           []string `json:"cc"`
+}
+
+type apActivity struct {
+	Context   any      `json:"@context,omitempty"`
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Actor     string   `json:"actor"`
+	Object    any      `json:"object"`
+	Published string   `json:"published,omitempty"`
+	To        []string `json:"to,omitempty"`
+	Cc        []string `json:"cc,omitempty"`
+}
+
+// apIncoming is an activity posted to the inbox
+type apIncoming struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+type apCollection struct {
+	Context      any    `json:"@context"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems []any  `json:"orderedItems,omitempty"`
+}
+
+type apFollower struct {
+	Actor string `json:"actor"`
+	Inbox string `json:"inbox"`
+}
+
+type activityPubState struct {
+	clippingTracker
+	// Followers is keyed by actor ID
+	Followers map[string]apFollower `json:"followers"`
+	// Published holds the latest published objects, newest first
+	Published []*apObject `json:"published"`
+}
+
+// ActivityPub is an actor that Mastodon-compatible servers can follow. Every clipping with a
+// new source URL is published to its followers, delivered with HTTP signatures. The followers
+// and what was published are saved to a state file across restarts.
+type ActivityPub struct {
+	user       string
+	host       string
+	base       string
+	link       string
+	name       string
+	summary    string
+	objectType string
+	stateFile  string
+
+	key          *rsa.PrivateKey
+	publicKeyPem string
+	client       *http.Client
+	retryDelay   time.Duration
+
+	mu    sync.Mutex
+	state activityPubState
+
+	// ctx is cancelled to abort the deliveries in flight when shutting down, retries as soon as
+	// shutting down starts, as a retry would not be made before the exit anyway
+	ctx         context.Context
+	cancel      context.CancelFunc
+	retries     context.Context
+	stopRetries context.CancelFunc
+	// deliveries tracks the deliveries in flight
+	deliveries sync.WaitGroup
+}
+
+// NewActivityPub creates the actor of config.ActivityPubUser, or returns nil when it is not
+// configured. The signing key is read from activitypub.pem in config.StateDir, or generated
+// there on the first start.
+func NewActivityPub(config Config) (*ActivityPub, error) {
+	if config.ActivityPubUser == "" {
+		return nil, nil
+	}
+	if !activityPubUserPattern.MatchString(config.ActivityPubUser) {
+		return nil, fmt.Errorf("invalid ActivityPub user %q: use letters, digits and '_'", config.ActivityPubUser)
+	}
+	link, err := url.Parse(config.FeedLink)
+	if err != nil || (link.Scheme != "http" && link.Scheme != "https") || link.Host == "" {
+		return nil, fmt.Errorf("invalid feed link %q: the ActivityPub actor needs an absolute http or https URL", config.FeedLink)
+	}
+	if config.ActivityPubObject != "Note" && config.ActivityPubObject != "Link" {
+		return nil, fmt.Errorf("invalid ActivityPub object type %q: expected Note or Link", config.ActivityPubObject)
+	}
+
+	stateFile, err := statePath(config, "the ActivityPub actor", "activitypub.json")
+	if err != nil {
+		return nil, err
+	}
+
+	ap := &ActivityPub{
+		user:       config.ActivityPubUser,
+		host:       link.Host,
+		base:       feedURL(config.FeedLink, "ap"),
+		link:       config.FeedLink,
+		name:       config.FeedTitle,
+		summary:    config.FeedDesc,
+		objectType: config.ActivityPubObject,
+		stateFile:  stateFile,
+		client:     &http.Client{Timeout: activityPubTimeout},
+		retryDelay: 10 * time.Second,
+		state: activityPubState{
+			Followers: make(map[string]apFollower),
+		},
+	}
+	ap.ctx, ap.cancel = context.WithCancel(context.Background())
+	ap.retries, ap.stopRetries = context.WithCancel(ap.ctx)
+
+	if ap.key, err = loadActivityPubKey(filepath.Join(config.StateDir, "activitypub.pem")); err != nil {
+		return nil, err
+	}
+	if ap.publicKeyPem, err = encodePublicKey(&ap.key.PublicKey); err != nil {
+		return nil, err
+	}
+
+	if _, err := loadState(ap.stateFile, &ap.state); err != nil {
+		return nil, fmt.Errorf("failed to load ActivityPub state: %w", err)
+	}
+	if ap.state.Followers == nil {
+		ap.state.Followers = make(map[string]apFollower)
+	}
+
+	return ap, nil
+}
+
+// loadActivityPubKey reads the PEM encoded RSA private key at filename, generating it if it
+// does not exist yet
+func loadActivityPubKey(filename string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ActivityPub key: %w", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode ActivityPub key: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", filename, err)
+		}
+		if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write ActivityPub key: %w", err)
+		}
+		slog.Info("Generated ActivityPub key", "file", filename)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ActivityPub key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in ActivityPub key %s", filename)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ActivityPub key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ActivityPub key type %T", key)
+	}
+	return rsaKey, nil
+}
+
+func (ap *ActivityPub) actorID() string     { return ap.base + "/actor" }
+func (ap *ActivityPub) keyID() string       { return ap.actorID() + "#main-key" }
+func (ap *ActivityPub) followersID() string { return ap.base + "/followers" }
+
+// save writes the state file; the caller holds ap.mu
+func (ap *ActivityPub) save() {
+	if err := saveState(ap.stateFile, ap.state); err != nil {
+		slog.Error("Failed to save ActivityPub state", "error", err)
+	}
+}
+
+// Notify publishes the clippings added to vault in gen to the followers
+func (ap *ActivityPub) Notify(vault string, gen *Generation) {
+	if gen.api == nil {
+		return
+	}
+
+	ap.mu.Lock()
+	added, changed := ap.state.add(vault, gen.api.items)
+	if !changed {
+		ap.mu.Unlock()
+		return
+	}
+	created := make([]*apObject, 0, len(added))
+	for _, item := range added {
+		created = append(created, ap.newObject(item))
+	}
+	ap.state.Published = append(created, ap.state.Published...)
+	ap.state.Published = ap.state.Published[:min(len(ap.state.Published), activityPubOutboxLimit)]
+	ap.save()
+	inboxes := ap.inboxes()
+	ap.mu.Unlock()
+
+	// Items are newest first, followers get the oldest first
+	for i := len(created) - 1; i >= 0; i-- {
+		activity := ap.create(created[i])
+		for _, inbox := range inboxes {
+			ap.deliverAsync(inbox, activity)
+		}
+	}
+}
+
+// newObject describes item as the configured object type
+func (ap *ActivityPub) newObject(item apiItem) *apObject {
+	published := item.Created
+	if published.IsZero() {
+		published = time.Now()
+	}
+	object := &apObject{
+		ID:           ap.base + "/objects/" + item.ID,
+		Type:         ap.objectType,
+		AttributedTo: ap.actorID(),
+		Published:    published.UTC().Format(time.RFC3339),
+		To:           []string{activityStreamsPublic},
+		Cc:           []string{ap.followersID()},
+	}
+
+	if ap.objectType == "Link" {
+		object.Href = item.Source
+		object.Name = item.Title
+		object.MediaType = "text/html"
+		return object
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, `<p><a href="%s" rel="nofollow noopener noreferrer" target="_blank">%s</a></p>`,
+		html.EscapeString(item.Source), html.EscapeString(item.Title))
+	if item.Description != "" {
+		fmt.Fprintf(&content, "<p>%s</p>", html.EscapeString(item.Description))
+	}
+	if item.Site != "" {
+		fmt.Fprintf(&content, "<p>%s</p>", html.EscapeString(item.Site))
+	}
+	object.Content = content.String()
+	object.URL = item.Source
+	return object
+}
+
+// create wraps object in the Create activity that publishes it
+func (ap *ActivityPub) create(object *apObject) apActivity {
+	return apActivity{
+		Context:   activityStreamsContext,
+		ID:        object.ID + "/activity",
+		Type:      "Create",
+		Actor:     ap.actorID(),
+		Object:    object,
+		Published: object.Published,
+		To:        object.To,
+		Cc:        object.Cc,
+	}
+}
+
+// inboxes returns the inboxes of the followers, each once; the caller holds ap.mu
+func (ap *ActivityPub) inboxes() []string {
+	var inboxes []string
+	for _, follower := range ap.state.Followers {
+		inboxes = append(inboxes, follower.Inbox)
+	}
+	slices.Sort(inboxes)
+	return slices.Compact(inboxes)
+}
+
+// deliverAsync delivers activity to inbox in the background, retrying failures
+func (ap *ActivityPub) deliverAsync(inbox string, activity apActivity) {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		slog.Error("Failed to encode activity", "type", activity.Type, "error", err)
+		return
+	}
+
+	ap.deliveries.Add(1)
+	go func() {
+		defer ap.deliveries.Done()
+
+		delay := ap.retryDelay
+		for attempt := 1; ; attempt++ {
+			retry, err := ap.deliver(ap.ctx, inbox, body)
+			if err == nil {
+				slog.Debug("Delivered activity", "type", activity.Type, "inbox", inbox)
+				return
+			}
+			if !retry || attempt == activityPubAttempts || ap.retries.Err() != nil {
+				slog.Error("Failed to deliver activity", "type", activity.Type, "inbox", inbox, "attempts", attempt, "error", err)
+				return
+			}
+			select {
+			case <-ap.retries.Done():
+				slog.Error("Failed to deliver activity before shutdown", "type", activity.Type, "inbox", inbox, "attempts", attempt, "error", err)
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	}()
+}
+
+// deliver posts a signed activity to inbox. retry reports whether a failure is worth retrying.
+func (ap *ActivityPub) deliver(ctx context.Context, inbox string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+	if err := signRequest(req, body, ap.keyID(), ap.key); err != nil {
+		return false, err
+	}
+
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post activity: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("inbox responded with %s", resp.Status)
+	default:
+		return false, fmt.Errorf("inbox responded with %s", resp.Status)
+	}
+}
+
+// wait gives up the pending retries and waits for the deliveries in flight to finish. When ctx
+// is done first, they are cancelled.
+func (ap *ActivityPub) wait(ctx context.Context) error {
+	ap.stopRetries()
+	return waitTasks(ctx, &ap.deliveries, ap.cancel)
+}
+
+// fetchActor fetches the actor document at actorURL with a signed request, as servers in secure
+// mode require. The document has to be the one of the actor it was fetched from, so one server
+// cannot speak for actors of another.
+func (ap *ActivityPub) fetchActor(ctx context.Context, actorURL string) (*apActor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, actorURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", activityJSONType)
+	if err := signRequest(req, nil, ap.keyID(), ap.key); err != nil {
+		return nil, err
+	}
+
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch actor: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("actor responded with %s", resp.Status)
+	}
+
+	var actor apActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, activityPubMaxBody)).Decode(&actor); err != nil {
+		return nil, fmt.Errorf("failed to decode actor: %w", err)
+	}
+	if actor.ID != actorURL {
+		return nil, fmt.Errorf("document at %s is the actor %q", actorURL, actor.ID)
+	}
+	if actor.Inbox == "" || actor.PublicKey.ID == "" || (actor.PublicKey.Owner != "" && actor.PublicKey.Owner != actor.ID) {
+		return nil, errors.New("actor has no inbox or no key of its own")
+	}
+	return &actor, nil
+}
+
+// register adds WebFinger and the endpoints of the actor to mux
+func (ap *ActivityPub) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/webfinger", ap.handleWebFinger)
+	mux.HandleFunc("GET /ap/actor", func(w http.ResponseWriter, r *http.Request) {
+		writeActivityJSON(w, http.StatusOK, apActor{
+			Context:           []string{activityStreamsContext, securityContext},
+			ID:                ap.actorID(),
+			Type:              "Service",
+			PreferredUsername: ap.user,
+			Name:              ap.name,
+			Summary:           ap.summary,
+			URL:               ap.link,
+			Inbox:             ap.base + "/inbox",
+			Outbox:            ap.base + "/outbox",
+			Followers:         ap.followersID(),
+			PublicKey:         apPublicKey{ID: ap.keyID(), Owner: ap.actorID(), PublicKeyPem: ap.publicKeyPem},
+		})
+	})
+	mux.HandleFunc("GET /ap/outbox", func(w http.ResponseWriter, r *http.Request) {
+		ap.mu.Lock()
+		items := make([]any, 0, len(ap.state.Published))
+		for _, object := range ap.state.Published {
+			items = append(items, ap.create(object))
+		}
+		ap.mu.Unlock()
+		writeActivityJSON(w, http.StatusOK, apCollection{
+			Context:      activityStreamsContext,
+			ID:           ap.base + "/outbox",
+			Type:         "OrderedCollection",
+			TotalItems:   len(items),
+			OrderedItems: items,
+		})
+	})
+	mux.HandleFunc("GET /ap/followers", func(w http.ResponseWriter, r *http.Request) {
+		ap.mu.Lock()
+		total := len(ap.state.Followers)
+		ap.mu.Unlock()
+		writeActivityJSON(w, http.StatusOK, apCollection{
+			Context:    activityStreamsContext,
+			ID:         ap.followersID(),
+			Type:       "OrderedCollection",
+			TotalItems: total,
+		})
+	})
+	mux.HandleFunc("GET /ap/objects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := ap.base + "/objects/" + r.PathValue("id")
+		ap.mu.Lock()
+		i := slices.IndexFunc(ap.state.Published, func(object *apObject) bool { return object.ID == id })
+		var object apObject
+		if i >= 0 {
+			object = *ap.state.Published[i]
+		}
+		ap.mu.Unlock()
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, apiError("object not found"))
+			return
+		}
+		writeActivityJSON(w, http.StatusOK, struct {
+			Context string `json:"@context"`
+			apObject
+		}{activityStreamsContext, object})
+	})
+	mux.HandleFunc("POST /ap/inbox", ap.handleInbox)
+}
+
+// handleWebFinger resolves acct:user@host, or the actor URL, to the actor
+func (ap *ActivityPub) handleWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		writeJSON(w, http.StatusBadRequest, apiError("missing resource"))
+		return
+	}
+	subject := "acct:" + ap.user + "@" + ap.host
+	if !strings.EqualFold(resource, subject) && resource != ap.actorID() {
+		writeJSON(w, http.StatusNotFound, apiError("unknown resource"))
+		return
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"subject": subject,
+		"aliases": []string{ap.actorID()},
+		"links": []map[string]string{
+			{"rel": "self", "type": activityJSONType, "href": ap.actorID()},
+			{"rel": "http://webfinger.net/rel/profile-page", "type": "text/html", "href": ap.link},
+		},
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError("failed to encode response"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/jrd+json; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	_, _ = w.Write(append(data, '\n'))
+}
+
+// handleInbox accepts Follow and Undo Follow activities signed by the actor sending them;
+// anything else is acknowledged and ignored
+func (ap *ActivityPub) handleInbox(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, activityPubMaxBody))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError("failed to read body"))
+		return
+	}
+	var activity apIncoming
+	if err := json.Unmarshal(body, &activity); err != nil || activity.Type == "" || activity.Actor == "" {
+		writeJSON(w, http.StatusBadRequest, apiError("invalid activity"))
+		return
+	}
+
+	// The key has to be the one of the actor of the activity, which is only fetched when the
+	// key is on its server
+	var actor *apActor
+	_, err = verifyRequest(r, body, func(keyID string) (*rsa.PublicKey, error) {
+		if !sameOrigin(keyID, activity.Actor) {
+			return nil, fmt.Errorf("key %s is not on the server of %s", keyID, activity.Actor)
+		}
+		fetched, err := ap.fetchActor(r.Context(), activity.Actor)
+		if err != nil {
+			return nil, err
+		}
+		if fetched.PublicKey.ID != keyID {
+			return nil, fmt.Errorf("key %s is not the key of %s", keyID, activity.Actor)
+		}
+		actor = fetched
+		return decodePublicKey(actor.PublicKey.PublicKeyPem)
+	})
+	if err != nil {
+		slog.Warn("Rejected unsigned ActivityPub activity", "type", activity.Type, "actor", activity.Actor, "error", err)
+		writeJSON(w, http.StatusUnauthorized, apiError("invalid signature"))
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		var object string
+		if err := json.Unmarshal(activity.Object, &object); err != nil || object != ap.actorID() {
+			writeJSON(w, http.StatusBadRequest, apiError("can only follow "+ap.actorID()))
+			return
+		}
+
+		inbox := actor.Inbox
+		if actor.Endpoints != nil && actor.Endpoints.SharedInbox != "" {
+			inbox = actor.Endpoints.SharedInbox
+		}
+		ap.mu.Lock()
+		ap.state.Followers[actor.ID] = apFollower{Actor: actor.ID, Inbox: inbox}
+		ap.save()
+		ap.mu.Unlock()
+		slog.Info("New ActivityPub follower", "actor", actor.ID)
+
+		id := make([]byte, 8)
+		_, _ = rand.Read(id)
+		ap.deliverAsync(actor.Inbox, apActivity{
+			Context: activityStreamsContext,
+			ID:      ap.actorID() + "#accepts/" + hex.EncodeToString(id),
+			Type:    "Accept",
+			Actor:   ap.actorID(),
+			Object:  json.RawMessage(body),
+		})
+
+	case "Undo":
+		var undone apIncoming
+		if err := json.Unmarshal(activity.Object, &undone); err == nil && undone.Type == "Follow" && undone.Actor == actor.ID {
+			ap.mu.Lock()
+			delete(ap.state.Followers, actor.ID)
+			ap.save()
+			ap.mu.Unlock()
+			slog.Info("Removed ActivityPub follower", "actor", actor.ID)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sameOrigin reports whether the absolute http or https URLs a and b have the same scheme and host
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil || (ua.Scheme != "http" && ua.Scheme != "https") || ua.Host == "" {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
+
+func writeActivityJSON(w http.ResponseWriter, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, apiError("failed to encode response"))
+		return
+	}
+	w.Header().Set("Content-Type", activityJSONType+"; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n'))
+}
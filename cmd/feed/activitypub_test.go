@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+// fakeInstance is a Mastodon-like server with the actor alice. Its inbox records the activities
+// delivered to it after verifying their signatures with ours. The document of mallory, a third
+// party on the same server, claims to be alice but publishes mallory's key.
+type fakeInstance struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	mallory *rsa.PrivateKey
+	ours    func() *rsa.PublicKey
+
+	mu         sync.Mutex
+	activities []apIncoming
+	fetches    int
+}
+
+func newFakeInstance(t *testing.T, ours func() *rsa.PublicKey) *fakeInstance {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	mallory, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	instance := &fakeInstance{key: key, mallory: mallory, ours: ours}
+	instance.server = httptest.NewServer(instance)
+	t.Cleanup(instance.server.Close)
+	return instance
+}
+
+func (f *fakeInstance) actorID() string { return f.server.URL + "/users/alice" }
+
+func (f *fakeInstance) malloryID() string { return f.server.URL + "/users/mallory" }
+
+func (f *fakeInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/users/alice":
+		f.mu.Lock()
+		f.fetches++
+		f.mu.Unlock()
+		if r.Header.Get("Signature") == "" {
+			http.Error(w, "unsigned", http.StatusUnauthorized)
+			return
+		}
+		publicKey, _ := encodePublicKey(&f.key.PublicKey)
+		w.Header().Set("Content-Type", activityJSONType)
+		_ = json.NewEncoder(w).Encode(apActor{
+			ID:                f.actorID(),
+			Type:              "Person",
+			PreferredUsername: "alice",
+			Inbox:             f.actorID() + "/inbox",
+			PublicKey:         apPublicKey{ID: f.actorID() + "#main-key", Owner: f.actorID(), PublicKeyPem: publicKey},
+		})
+
+	case r.Method == http.MethodGet && r.URL.Path == "/users/mallory":
+		f.mu.Lock()
+		f.fetches++
+		f.mu.Unlock()
+		publicKey, _ := encodePublicKey(&f.mallory.PublicKey)
+		w.Header().Set("Content-Type", activityJSONType)
+		_ = json.NewEncoder(w).Encode(apActor{
+			ID:                f.actorID(),
+			Type:              "Person",
+			PreferredUsername: "alice",
+			Inbox:             f.malloryID() + "/inbox",
+			PublicKey:         apPublicKey{ID: f.malloryID() + "#main-key", PublicKeyPem: publicKey},
+		})
+
+	case r.Method == http.MethodPost && r.URL.Path == "/users/alice/inbox":
+		body, _ := io.ReadAll(r.Body)
+		_, err := verifyRequest(r, body, func(keyID string) (*rsa.PublicKey, error) {
+			if keyID != "https://feeds.example.com/ap/actor#main-key" {
+				return nil, errors.New("unknown key " + keyID)
+			}
+			return f.ours(), nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var activity apIncoming
+		if err := json.Unmarshal(body, &activity); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.activities = append(f.activities, activity)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeInstance) received() []apIncoming {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]apIncoming(nil), f.activities...)
+}
+
+// post sends activity to handler as alice, signed with her key unless unsigned is set
+func (f *fakeInstance) post(t *testing.T, handler http.Handler, activity map[string]any, unsigned bool) int {
+	t.Helper()
+
+	if unsigned {
+		return f.postSigned(t, handler, activity, "", nil)
+	}
+	return f.postSigned(t, handler, activity, f.actorID()+"#main-key", f.key)
+}
+
+// postSigned sends activity to handler signed with key as keyID, or unsigned if key is nil
+func (f *fakeInstance) postSigned(t *testing.T, handler http.Handler, activity map[string]any, keyID string, key *rsa.PrivateKey) int {
+	t.Helper()
+
+	body, err := json.Marshal(activity)
+	if err != nil {
+		t.Fatalf("Failed to encode activity: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "https://feeds.example.com/ap/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", activityJSONType)
+	if key != nil {
+		if err := signRequest(req, body, keyID, key); err != nil {
+			t.Fatalf("signRequest failed: %v", err)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func getActivityJSON(t *testing.T, handler http.Handler, target string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for %s, got %d: %s", target, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode %s: %v", target, err)
+	}
+	return rec
+}
+
+func TestActivityPub(t *testing.T) {
+	var vaults *VaultSet
+	instance := newFakeInstance(t, func() *rsa.PublicKey { return &vaults.activityPub.key.PublicKey })
+
+	dir := t.TempDir()
+	state := t.TempDir()
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+	config := Config{
+		TargetDir:         dir,
+		MaxItems:          50,
+		FeedTitle:         "Clippings",
+		FeedLink:          "https://feeds.example.com/",
+		ActivityPubUser:   "clippings",
+		StateDir:          state,
+		ActivityPubObject: "Note",
+	}
+	var err error
+	vaults, err = NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	var finger struct {
+		Subject string
+		Links   []struct{ Rel, Type, Href string }
+	}
+	rec := getActivityJSON(t, handler, "/.well-known/webfinger?resource=acct:clippings@feeds.example.com", &finger)
+	if rec.Header().Get("Content-Type") != "application/jrd+json; charset=utf-8" || finger.Subject != "acct:clippings@feeds.example.com" ||
+		len(finger.Links) == 0 || finger.Links[0].Href != "https://feeds.example.com/ap/actor" {
+		t.Errorf("Unexpected WebFinger response %+v", finger)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/webfinger?resource=acct:other@feeds.example.com", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown account, got %d", rec.Code)
+	}
+
+	var actor apActor
+	rec = getActivityJSON(t, handler, "/ap/actor", &actor)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), activityJSONType) || actor.PreferredUsername != "clippings" ||
+		actor.Inbox != "https://feeds.example.com/ap/inbox" || actor.PublicKey.Owner != actor.ID {
+		t.Errorf("Unexpected actor %+v", actor)
+	}
+	if _, err := decodePublicKey(actor.PublicKey.PublicKeyPem); err != nil {
+		t.Errorf("Expected the actor to publish its key: %v", err)
+	}
+
+	// Clippings already in the vault are not published
+	var outbox apCollection
+	getActivityJSON(t, handler, "/ap/outbox", &outbox)
+	if outbox.TotalItems != 0 {
+		t.Errorf("Expected an empty outbox, got %+v", outbox)
+	}
+
+	follow := map[string]any{
+		"@context": activityStreamsContext,
+		"id":       instance.actorID() + "/follows/1",
+		"type":     "Follow",
+		"actor":    instance.actorID(),
+		"object":   "https://feeds.example.com/ap/actor",
+	}
+	if code := instance.post(t, handler, follow, true); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unsigned Follow, got %d", code)
+	}
+	if code := instance.post(t, handler, map[string]any{"type": "Follow", "actor": "https://other.example.com/users/mallory",
+		"object": "https://feeds.example.com/ap/actor"}, false); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a Follow signed by another actor, got %d", code)
+	}
+	// A third party on the same server cannot speak for alice, neither through a document
+	// claiming her ID nor by signing with its own key
+	if code := instance.postSigned(t, handler, map[string]any{"type": "Follow", "actor": instance.malloryID(),
+		"object": "https://feeds.example.com/ap/actor"}, instance.malloryID()+"#main-key", instance.mallory); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a Follow by a document claiming another actor, got %d", code)
+	}
+	if code := instance.postSigned(t, handler, follow, instance.malloryID()+"#main-key", instance.mallory); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a Follow signed with another actor's key, got %d", code)
+	}
+	// Keys on other servers are not fetched at all
+	instance.mu.Lock()
+	fetches := instance.fetches
+	instance.mu.Unlock()
+	if code := instance.postSigned(t, handler, follow, "http://127.0.0.1:1/users/alice#main-key", instance.key); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a Follow signed with a key on another server, got %d", code)
+	}
+	instance.mu.Lock()
+	if instance.fetches != fetches {
+		t.Errorf("Expected no actor to be fetched for a key on another server")
+	}
+	instance.mu.Unlock()
+	if code := instance.post(t, handler, follow, false); code != http.StatusAccepted {
+		t.Fatalf("Expected 202 for a signed Follow, got %d", code)
+	}
+	waitFor(t, func() bool { return len(instance.received()) == 1 })
+	accept := instance.received()[0]
+	var accepted apIncoming
+	if err := json.Unmarshal(accept.Object, &accepted); err != nil || accept.Type != "Accept" || accepted.ID != follow["id"] {
+		t.Errorf("Expected the Follow to be accepted, got %+v", accept)
+	}
+
+	var followers apCollection
+	getActivityJSON(t, handler, "/ap/followers", &followers)
+	if followers.TotalItems != 1 {
+		t.Errorf("Expected 1 follower, got %d", followers.TotalItems)
+	}
+
+	// A new clipping is delivered to the followers and listed in the outbox
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "Second", Source: "https://example.com/second",
+		Description: "Worth <reading>", Created: time.Now()}})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	waitFor(t, func() bool { return len(instance.received()) == 2 })
+	create := instance.received()[1]
+	var note apObject
+	if err := json.Unmarshal(create.Object, &note); err != nil || create.Type != "Create" || create.Actor != actor.ID {
+		t.Fatalf("Expected a Create activity, got %+v", create)
+	}
+	if note.Type != "Note" || note.URL != "https://example.com/second" ||
+		!strings.Contains(note.Content, `<a href="https://example.com/second"`) || !strings.Contains(note.Content, "Worth &lt;reading&gt;") {
+		t.Errorf("Unexpected note %+v", note)
+	}
+
+	getActivityJSON(t, handler, "/ap/outbox", &outbox)
+	if outbox.TotalItems != 1 {
+		t.Errorf("Expected the clipping in the outbox, got %+v", outbox)
+	}
+	var object apObject
+	getActivityJSON(t, handler, strings.TrimPrefix(note.ID, "https://feeds.example.com"), &object)
+	if object.ID != note.ID {
+		t.Errorf("Expected the note to be served at its ID, got %+v", object)
+	}
+
+	// The key and the followers survive a restart
+	restarted, err := NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if !restarted.activityPub.key.Equal(vaults.activityPub.key) || len(restarted.activityPub.state.Followers) != 1 {
+		t.Errorf("Expected the key and the follower to be restored")
+	}
+	if info, err := os.Stat(filepath.Join(state, "activitypub.pem")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the key to be private, got %v, %v", info, err)
+	}
+
+	undo := map[string]any{
+		"id":     instance.actorID() + "/follows/1/undo",
+		"type":   "Undo",
+		"actor":  instance.actorID(),
+		"object": follow,
+	}
+	if code := instance.post(t, handler, undo, false); code != http.StatusAccepted {
+		t.Fatalf("Expected 202 for a signed Undo, got %d", code)
+	}
+	getActivityJSON(t, handler, "/ap/followers", &followers)
+	if followers.TotalItems != 0 {
+		t.Errorf("Expected the follower to be removed, got %d", followers.TotalItems)
+	}
+	if err := vaults.Shutdown(t.Context()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+}
+
+func TestActivityPubShutdown(t *testing.T) {
+	var attempts atomic.Int32
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+
+	ap, err := NewActivityPub(Config{ActivityPubUser: "clippings", FeedLink: "https://feeds.example.com/",
+		ActivityPubObject: "Note", StateDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewActivityPub failed: %v", err)
+	}
+	ap.retryDelay = time.Hour
+	activity := ap.create(ap.newObject(apiItem{ID: "1", Title: "First", Source: "https://example.com/first"}))
+
+	// A retry waiting at shutdown is given up
+	ap.deliverAsync(unavailable.URL+"/inbox", activity)
+	waitFor(t, func() bool { return attempts.Load() == 1 })
+	ap.deliverAsync(hanging.URL+"/inbox", activity)
+
+	// A delivery in flight is cancelled once the shutdown times out
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	if err := ap.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the hanging delivery to time out, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		ap.deliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the deliveries to stop after the shutdown")
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("Expected no retry after the shutdown, got %d attempts", got)
+	}
+}
+
+func TestNewActivityPubErrors(t *testing.T) {
+	tests := map[string]Config{
+		"invalid user":   {ActivityPubUser: "not valid", FeedLink: "https://feeds.example.com/", ActivityPubObject: "Note"},
+		"relative link":  {ActivityPubUser: "clippings", FeedLink: "/", ActivityPubObject: "Note"},
+		"unknown object": {ActivityPubUser: "clippings", FeedLink: "https://feeds.example.com/", ActivityPubObject: "Article"},
+		"no state dir":   {ActivityPubUser: "clippings", FeedLink: "https://feeds.example.com/", ActivityPubObject: "Note"},
+	}
+	for name, config := range tests {
+		if name != "no state dir" {
+			config.StateDir = t.TempDir()
+		}
+		if _, err := NewActivityPub(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if ap, err := NewActivityPub(Config{}); ap != nil || err != nil {
+		t.Errorf("Expected no actor without a user, got %v, %v", ap, err)
+	}
+}
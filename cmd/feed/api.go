@@ -0,0 +1,499 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+// The JSON API serves the clippings of the current generation under /api/ of every feed
+// mount. Items are the clippings with a title and a source, one per source URL.
+//
+//	GET /api/items
+//	    Lists items. Query parameters:
+//	      tag=NAME        items with the tag; repeat to require several
+//	      site=NAME       items from the site
+//	      author=NAME     items by the author
+//	      since=TIME      items created at or after TIME (RFC 3339 or YYYY-MM-DD)
+//	      until=TIME      items created before TIME
+//	      sort=KEY        created, title or published, prefixed with "-" for descending
+//	                      order; -created by default
+//	      limit=N         items per page, 1 to 500, 50 by default
+//	      cursor=TOKEN    next_cursor of the previous page
+//	      fields=A,B      item fields to return; id is always returned
+//	    Filters on names are case-insensitive. Responds with
+//	      {"items": [Item], "total": N, "next_cursor": "TOKEN"}
+//	    where total counts every matching item and next_cursor is left out on the last page.
+//
+//	GET /api/items/{id}
+//	    Responds with the Item, or 404.
+//
+//	GET /api/tags, /api/sites, /api/authors
+//	    Respond with {"tags": [Count]}, {"sites": [Count]} and {"authors": [Count]}.
+//
+//	GET /api/search?q=QUERY
+//	    Searches titles, descriptions, tags, sites and note bodies for items containing
+//	    every word of QUERY. limit=N caps the results as for /api/items. Responds with
+//	      {"query": "QUERY", "items": [Item], "total": N}
+//	    where items, best matches first, also have a "score".
+//
+// An Item is
+//
+//	{"id": "...", "title": "...", "source": "https://...", "site": "...", "author": ["..."],
+//	 "published": "...", "created": "RFC 3339 time", "description": "...", "tags": ["..."]}
+//
+// where id is derived from the source URL, so it is stable across restarts, and description
+// is empty when FEED_HIDE_DESCRIPTION is set. A Count is {"name": "...", "count": N}; counts
+// are sorted by count, then name. Errors respond with {"error": "message"}.
+
+const (
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+)
+
+var apiSortKeys = map[string]func(apiItem) string{
+	"created":   func(item apiItem) string { return item.Created.UTC().Format("2006-01-02T15:04:05.000000000Z") },
+	"title":     func(item apiItem) string { return strings.ToLower(item.Title) },
+	"published": func(item apiItem) string { return item.Published },
+}
+
+type apiItem struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Source      string    `json:"source"`
+	Site        string    `json:"site"`
+	Author      []string  `json:"author"`
+	Published   string    `json:"published"`
+	Created     time.Time `json:"created"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+}
+
+type apiCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type apiItemList struct {
+	Items      []any  `json:"items"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// apiCursor marks the last item of a page in the order it was sorted by
+type apiCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+// apiIndex holds the items of a generation, sorted newest first, and their facets
+type apiIndex struct {
+	items   []apiItem
+	byID    map[string]int
+	tags    []apiCount
+	sites   []apiCount
+	authors []apiCount
+}
+
+// apiItemID identifies the item for a source URL
+func apiItemID(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newAPIIndex(metadata []clippingsfeed.Metadata, hideDescription bool) *apiIndex {
+	valid := clippingsfeed.DeduplicateMetadataBySource(clippingsfeed.FilterValidMetadata(metadata))
+
+	index := &apiIndex{
+		items: make([]apiItem, 0, len(valid)),
+		byID:  make(map[string]int, len(valid)),
+	}
+	tags := make(apiCounter)
+	sites := make(apiCounter)
+	authors := make(apiCounter)
+
+	for _, meta := range valid {
+		index.items = append(index.items, newAPIItem(meta, hideDescription))
+
+		tags.add(meta.Tags...)
+		if meta.Site != "" {
+			sites.add(meta.Site)
+		}
+		authors.add(meta.Author...)
+	}
+
+	slices.SortFunc(index.items, apiCompare("created", true))
+	for i, item := range index.items {
+		index.byID[item.ID] = i
+	}
+	index.tags = tags.counts()
+	index.sites = sites.counts()
+	index.authors = authors.counts()
+
+	return index
+}
+
+func newAPIItem(meta clippingsfeed.Metadata, hideDescription bool) apiItem {
+	item := apiItem{
+		ID:        apiItemID(meta.Source),
+		Title:     meta.Title,
+		Source:    meta.Source,
+		Site:      meta.Site,
+		Author:    nonNil(meta.Author),
+		Published: meta.Published,
+		Created:   meta.Created,
+		Tags:      nonNil(meta.Tags),
+	}
+	if !hideDescription {
+		item.Description = meta.Description
+	}
+	return item
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// apiCounter counts the items per name, telling names apart like the filters do with sameName.
+// It maps each name, lowercased and without a leading '#', to the count of every spelling.
+type apiCounter map[string]map[string]int
+
+// add counts an item with names, each of which is counted once
+func (c apiCounter) add(names ...string) {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		key := strings.ToLower(strings.TrimPrefix(name, "#"))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		if c[key] == nil {
+			c[key] = make(map[string]int)
+		}
+		c[key][name]++
+	}
+}
+
+// counts lists every name in its most common spelling, most frequent names first
+func (c apiCounter) counts() []apiCount {
+	sorted := make([]apiCount, 0, len(c))
+	for _, spellings := range c {
+		var count apiCount
+		var best int
+		for name, n := range spellings {
+			count.Count += n
+			if n > best || (n == best && name < count.Name) {
+				count.Name, best = name, n
+			}
+		}
+		sorted = append(sorted, count)
+	}
+	slices.SortFunc(sorted, func(a, b apiCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Name, b.Name))
+	})
+	return sorted
+}
+
+// apiCompare orders items by key, breaking ties by ID so that pages never overlap
+func apiCompare(key string, descending bool) func(a, b apiItem) int {
+	keyOf := apiSortKeys[key]
+	return func(a, b apiItem) int {
+		c := strings.Compare(keyOf(a), keyOf(b))
+		if descending {
+			c = -c
+		}
+		return cmp.Or(c, strings.Compare(a.ID, b.ID))
+	}
+}
+
+// apiQuery is a parsed /api/items request
+type apiQuery struct {
+	tags       []string
+	site       string
+	author     string
+	since      time.Time
+	until      time.Time
+	sort       string
+	descending bool
+	limit      int
+	cursor     *apiCursor
+	fields     []string
+}
+
+func parseAPIQuery(values map[string][]string) (*apiQuery, error) {
+	get := func(name string) string {
+		if v := values[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	q := &apiQuery{
+		tags:   values["tag"],
+		site:   get("site"),
+		author: get("author"),
+		sort:   "-created",
+	}
+
+	var err error
+	if q.since, err = parseAPITime(get("since")); err != nil {
+		return nil, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.until, err = parseAPITime(get("until")); err != nil {
+		return nil, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if sort := get("sort"); sort != "" {
+		q.sort = sort
+	}
+	key := strings.TrimPrefix(q.sort, "-")
+	if _, ok := apiSortKeys[key]; !ok {
+		return nil, fmt.Errorf("invalid sort %q (supported: created, title, published)", q.sort)
+	}
+	q.descending = key != q.sort
+
+	if q.limit, err = parseAPILimit(get("limit")); err != nil {
+		return nil, err
+	}
+
+	if cursor := get("cursor"); cursor != "" {
+		q.cursor = &apiCursor{}
+		data, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err == nil {
+			err = json.Unmarshal(data, q.cursor)
+		}
+		if err != nil || q.cursor.Sort != q.sort {
+			return nil, errors.New("invalid cursor")
+		}
+	}
+
+	if fields := get("fields"); fields != "" {
+		known, _ := apiItemFields(apiItem{})
+		for _, field := range strings.Split(fields, ",") {
+			field = strings.TrimSpace(field)
+			if _, ok := known[field]; !ok {
+				return nil, fmt.Errorf("unknown field %q", field)
+			}
+			q.fields = append(q.fields, field)
+		}
+	}
+
+	return q, nil
+}
+
+func parseAPILimit(value string) (int, error) {
+	if value == "" {
+		return apiDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > apiMaxLimit {
+		return 0, fmt.Errorf("invalid limit %q (must be between 1 and %d)", value, apiMaxLimit)
+	}
+	return limit, nil
+}
+
+func parseAPITime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// matches reports whether item passes the filters of q
+func (q *apiQuery) matches(item apiItem) bool {
+	for _, tag := range q.tags {
+		if !slices.ContainsFunc(item.Tags, func(t string) bool { return sameName(t, tag) }) {
+			return false
+		}
+	}
+	if q.site != "" && !sameName(item.Site, q.site) {
+		return false
+	}
+	if q.author != "" && !slices.ContainsFunc(item.Author, func(a string) bool { return sameName(a, q.author) }) {
+		return false
+	}
+	if !q.since.IsZero() && item.Created.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && !item.Created.Before(q.until) {
+		return false
+	}
+	return true
+}
+
+// sameName compares tags, sites and authors, ignoring case and the # of tags
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimPrefix(a, "#"), strings.TrimPrefix(b, "#"))
+}
+
+// list returns the page of items q asks for
+func (index *apiIndex) list(q *apiQuery) (*apiItemList, error) {
+	key := strings.TrimPrefix(q.sort, "-")
+	compare := apiCompare(key, q.descending)
+
+	var matched []apiItem
+	for _, item := range index.items {
+		if q.matches(item) {
+			matched = append(matched, item)
+		}
+	}
+	slices.SortFunc(matched, compare)
+
+	start := 0
+	if q.cursor != nil {
+		// Resume after the last item of the previous page, even if it is gone since
+		keyOf := apiSortKeys[key]
+		start, _ = slices.BinarySearchFunc(matched, q.cursor, func(item apiItem, cursor *apiCursor) int {
+			c := strings.Compare(keyOf(item), cursor.Key)
+			if q.descending {
+				c = -c
+			}
+			return cmp.Or(c, strings.Compare(item.ID, cursor.ID))
+		})
+		if start < len(matched) && matched[start].ID == q.cursor.ID {
+			start++
+		}
+	}
+	end := min(start+q.limit, len(matched))
+
+	result := &apiItemList{Items: make([]any, 0, end-start), Total: len(matched)}
+	for _, item := range matched[start:end] {
+		selected, err := item.selectFields(q.fields)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, selected)
+	}
+
+	if end < len(matched) {
+		last := matched[end-1]
+		data, err := json.Marshal(apiCursor{Sort: q.sort, Key: apiSortKeys[key](last), ID: last.ID})
+		if err != nil {
+			return nil, err
+		}
+		result.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	return result, nil
+}
+
+// selectFields returns item with only id and fields, or the whole item without fields
+func (item apiItem) selectFields(fields []string) (any, error) {
+	if len(fields) == 0 {
+		return item, nil
+	}
+
+	all, err := apiItemFields(item)
+	if err != nil {
+		return nil, err
+	}
+	selected := map[string]json.RawMessage{"id": all["id"]}
+	for _, field := range fields {
+		selected[field] = all[field]
+	}
+	return selected, nil
+}
+
+func apiItemFields(item apiItem) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// registerAPI adds the JSON API for the generations of store to mux
+func registerAPI(mux *http.ServeMux, store *Store) {
+	mux.HandleFunc("GET /api/items", apiHandler(store, func(gen *Generation, r *http.Request) (int, any) {
+		q, err := parseAPIQuery(r.URL.Query())
+		if err != nil {
+			return http.StatusBadRequest, apiError(err.Error())
+		}
+		list, err := gen.api.list(q)
+		if err != nil {
+			return http.StatusInternalServerError, apiError(err.Error())
+		}
+		return http.StatusOK, list
+	}))
+	mux.HandleFunc("GET /api/items/{id}", apiHandler(store, func(gen *Generation, r *http.Request) (int, any) {
+		i, ok := gen.api.byID[r.PathValue("id")]
+		if !ok {
+			return http.StatusNotFound, apiError("item not found")
+		}
+		return http.StatusOK, gen.api.items[i]
+	}))
+	mux.HandleFunc("GET /api/tags", apiHandler(store, func(gen *Generation, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string][]apiCount{"tags": gen.api.tags}
+	}))
+	mux.HandleFunc("GET /api/sites", apiHandler(store, func(gen *Generation, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string][]apiCount{"sites": gen.api.sites}
+	}))
+	mux.HandleFunc("GET /api/authors", apiHandler(store, func(gen *Generation, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string][]apiCount{"authors": gen.api.authors}
+	}))
+	mux.HandleFunc("GET /api/search", apiHandler(store, func(gen *Generation, r *http.Request) (int, any) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			return http.StatusBadRequest, apiError("missing q")
+		}
+		limit, err := parseAPILimit(r.URL.Query().Get("limit"))
+		if err != nil {
+			return http.StatusBadRequest, apiError(err.Error())
+		}
+
+		results := gen.search.search(query)
+		return http.StatusOK, apiSearchResults{Query: query, Items: results[:min(limit, len(results))], Total: len(results)}
+	}))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusNotFound, apiError("unknown API endpoint"))
+	})
+}
+
+func apiHandler(store *Store, handle func(*Generation, *http.Request) (int, any)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gen := store.Current()
+		if gen == nil || gen.api == nil {
+			writeJSON(w, http.StatusServiceUnavailable, apiError("feeds are not generated yet"))
+			return
+		}
+		w.Header().Set("Last-Modified", gen.Created.UTC().Format(http.TimeFormat))
+		status, body := handle(gen, r)
+		writeJSON(w, status, body)
+	}
+}
+
+func apiError(message string) any {
+	return map[string]string{"error": message}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		data = []byte(`{"error":"failed to encode response"}`)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(append(data, '\n'))
+}
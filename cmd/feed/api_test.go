@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+var apiTestMetadata = []clippingsfeed.Metadata{
+	{
+		Title:       "Go Generics",
+		Source:      "https://example.com/generics",
+		Site:        "Example",
+		Author:      []string{"Alice"},
+		Published:   "2024-01-10",
+		Created:     time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC),
+		Description: "About generics",
+		Tags:        []string{"go", "clippings"},
+	},
+	{
+		Title:     "Rust Traits",
+		Source:    "https://example.org/traits",
+		Site:      "Example Org",
+		Author:    []string{"Bob", "Alice"},
+		Published: "2024-02-01",
+		Created:   time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC),
+		Tags:      []string{"rust", "clippings"},
+	},
+	{
+		Title:   "another go post",
+		Source:  "https://example.com/another",
+		Site:    "example",
+		Created: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC),
+		Tags:    []string{"Go"},
+	},
+	{
+		Title:   "Duplicate of generics",
+		Source:  "https://example.com/generics",
+		Created: time.Date(2024, 4, 1, 9, 0, 0, 0, time.UTC),
+	},
+	{Title: "No source"},
+}
+
+func newAPITestHandler(t *testing.T, config Config) http.Handler {
+	t.Helper()
+
+	store := NewStore()
+	gen, err := NewFeedGenerator(config, store).buildGeneration(apiTestMetadata)
+	if err != nil {
+		t.Fatalf("Failed to build generation: %v", err)
+	}
+	store.Publish(gen)
+	return newStoreHandler(store)
+}
+
+func getJSON(t *testing.T, handler http.Handler, target string, expectedStatus int, result any) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != expectedStatus {
+		t.Fatalf("GET %s: expected status %d, got %d: %s", target, expectedStatus, rec.Code, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+		t.Errorf("GET %s: unexpected Content-Type %q", target, contentType)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), result); err != nil {
+		t.Fatalf("GET %s: invalid JSON: %v", target, err)
+	}
+}
+
+type apiTestList struct {
+	Items      []map[string]any `json:"items"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+func titles(list apiTestList) []string {
+	var titles []string
+	for _, item := range list.Items {
+		titles = append(titles, item["title"].(string))
+	}
+	return titles
+}
+
+func TestAPIItems(t *testing.T) {
+	handler := newAPITestHandler(t, Config{MaxItems: 1})
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "newest first", query: "", expected: []string{"another go post", "Rust Traits", "Go Generics"}},
+		{name: "sort by title", query: "sort=title", expected: []string{"another go post", "Go Generics", "Rust Traits"}},
+		{name: "sort by published descending", query: "sort=-published", expected: []string{"Rust Traits", "Go Generics", "another go post"}},
+		{name: "tag ignores case", query: "tag=go", expected: []string{"another go post", "Go Generics"}},
+		{name: "several tags", query: "tag=go&tag=%23clippings", expected: []string{"Go Generics"}},
+		{name: "site", query: "site=EXAMPLE", expected: []string{"another go post", "Go Generics"}},
+		{name: "author", query: "author=alice", expected: []string{"Rust Traits", "Go Generics"}},
+		{name: "since date", query: "since=2024-02-01", expected: []string{"another go post", "Rust Traits"}},
+		{name: "until time", query: "until=2024-02-01T09:00:00Z", expected: []string{"Go Generics"}},
+		{name: "no match", query: "tag=python", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var list apiTestList
+			getJSON(t, handler, "/api/items?"+tt.query, http.StatusOK, &list)
+			if got := titles(list); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+			if list.Total != len(tt.expected) {
+				t.Errorf("Expected total %d, got %d", len(tt.expected), list.Total)
+			}
+		})
+	}
+}
+
+func TestAPIItemsPagination(t *testing.T) {
+	handler := newAPITestHandler(t, Config{})
+
+	var pages [][]string
+	query := url.Values{"sort": {"title"}, "limit": {"2"}}
+	for range 3 {
+		var list apiTestList
+		getJSON(t, handler, "/api/items?"+query.Encode(), http.StatusOK, &list)
+		if list.Total != 3 {
+			t.Errorf("Expected total 3, got %d", list.Total)
+		}
+		pages = append(pages, titles(list))
+		if list.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", list.NextCursor)
+	}
+
+	expected := [][]string{{"another go post", "Go Generics"}, {"Rust Traits"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("Expected pages %v, got %v", expected, pages)
+	}
+
+	// A cursor is bound to the order it was issued for
+	query.Set("sort", "-created")
+	var apiErr map[string]string
+	getJSON(t, handler, "/api/items?"+query.Encode(), http.StatusBadRequest, &apiErr)
+	if apiErr["error"] != "invalid cursor" {
+		t.Errorf("Expected invalid cursor error, got %v", apiErr)
+	}
+}
+
+func TestAPIItemsFields(t *testing.T) {
+	handler := newAPITestHandler(t, Config{})
+
+	var list apiTestList
+	getJSON(t, handler, "/api/items?fields=title,tags&limit=1", http.StatusOK, &list)
+	expected := map[string]any{
+		"id":    apiItemID("https://example.com/another"),
+		"title": "another go post",
+		"tags":  []any{"Go"},
+	}
+	if len(list.Items) != 1 || !reflect.DeepEqual(list.Items[0], expected) {
+		t.Errorf("Expected %v, got %v", expected, list.Items)
+	}
+}
+
+func TestAPIItemsInvalidQuery(t *testing.T) {
+	handler := newAPITestHandler(t, Config{})
+
+	for _, query := range []string{"sort=size", "limit=0", "limit=501", "limit=x", "since=yesterday", "fields=title,body", "cursor=not-a-cursor"} {
+		t.Run(query, func(t *testing.T) {
+			var apiErr map[string]string
+			getJSON(t, handler, "/api/items?"+query, http.StatusBadRequest, &apiErr)
+			if apiErr["error"] == "" {
+				t.Errorf("Expected an error message, got %v", apiErr)
+			}
+		})
+	}
+}
+
+func TestAPIItem(t *testing.T) {
+	handler := newAPITestHandler(t, Config{HideDescription: true})
+
+	var item map[string]any
+	getJSON(t, handler, "/api/items/"+apiItemID("https://example.com/generics"), http.StatusOK, &item)
+	expected := map[string]any{
+		"id":          apiItemID("https://example.com/generics"),
+		"title":       "Go Generics",
+		"source":      "https://example.com/generics",
+		"site":        "Example",
+		"author":      []any{"Alice"},
+		"published":   "2024-01-10",
+		"created":     "2024-01-10T09:00:00Z",
+		"description": "",
+		"tags":        []any{"go", "clippings"},
+	}
+	if !reflect.DeepEqual(item, expected) {
+		t.Errorf("Expected %v, got %v", expected, item)
+	}
+
+	var apiErr map[string]string
+	getJSON(t, handler, "/api/items/missing", http.StatusNotFound, &apiErr)
+	getJSON(t, handler, "/api/unknown", http.StatusNotFound, &apiErr)
+}
+
+func TestAPICounts(t *testing.T) {
+	handler := newAPITestHandler(t, Config{})
+
+	// Names are counted like the filters match them, ignoring case
+	tests := map[string][]apiCount{
+		"tags":    {{Name: "Go", Count: 2}, {Name: "clippings", Count: 2}, {Name: "rust", Count: 1}},
+		"sites":   {{Name: "Example", Count: 2}, {Name: "Example Org", Count: 1}},
+		"authors": {{Name: "Alice", Count: 2}, {Name: "Bob", Count: 1}},
+	}
+
+	for name, expected := range tests {
+		t.Run(name, func(t *testing.T) {
+			var counts map[string][]apiCount
+			getJSON(t, handler, "/api/"+name, http.StatusOK, &counts)
+			if !reflect.DeepEqual(counts[name], expected) {
+				t.Errorf("Expected %v, got %v", expected, counts[name])
+			}
+		})
+	}
+}
+
+func TestAPICounter(t *testing.T) {
+	counter := make(apiCounter)
+	counter.add("#go", "Go")
+	counter.add("go", "rust")
+	counter.add("#go")
+
+	// An item counts once per name, listed in its most common spelling
+	expected := []apiCount{{Name: "#go", Count: 3}, {Name: "rust", Count: 1}}
+	if got := counter.counts(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestAPINotGenerated(t *testing.T) {
+	var apiErr map[string]string
+	getJSON(t, newStoreHandler(NewStore()), "/api/items", http.StatusServiceUnavailable, &apiErr)
+}
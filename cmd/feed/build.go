@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+)
+
+// runBuild scans every vault once, writes every output to the directory given by -out and
+// returns. It backs the "feed build" subcommand used to publish to static hosts, so no webhook,
+// WebSub hub, ActivityPub follower or digest recipient is notified.
+func runBuild(ctx context.Context, config Config, args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	out := flags.String("out", "./public", "directory to write the generated feeds to")
+	flags.StringVar(&config.TargetDir, "target", config.TargetDir, "vault directory, archive or URL to scan when FEED_ROOTS is not set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	config.ExportDir = *out
+	vaults, err := newVaultSet(config, false)
+	if err != nil {
+		return err
+	}
+	vaults.setUpdateMode("static build")
+
+	if err := vaults.GenerateFeeds(ctx); err != nil {
+		return err
+	}
+
+	slog.Info("Built feeds", "vaults", len(vaults.Vaults()), "outDir", *out)
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+func TestRunBuild(t *testing.T) {
+	vaultDir := t.TempDir()
+	outDir := filepath.Join(t.TempDir(), "public")
+
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	for _, meta := range metadata {
+		filename := filepath.Join(vaultDir, meta.Title+".md")
+		if err := os.WriteFile(filename, []byte(createMarkdownContent(meta)), 0644); err != nil {
+			t.Fatalf("Failed to write test markdown file: %v", err)
+		}
+	}
+
+	config := Config{FeedTitle: "Static Feed", MaxItems: 50}
+	if err := runBuild(t.Context(), config, []string{"-target", vaultDir, "-out", outDir}); err != nil {
+		t.Fatalf("runBuild failed: %v", err)
+	}
+
+	for _, filename := range []string{"feed.rss", "feed.atom", "feed.json", "index.html"} {
+		content, err := os.ReadFile(filepath.Join(outDir, filename))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+		for _, meta := range metadata {
+			if !strings.Contains(string(content), meta.Title) {
+				t.Errorf("%s does not contain %s", filename, meta.Title)
+			}
+		}
+
+		for _, encoding := range clippingsfeed.Encodings {
+			if _, err := os.Stat(filepath.Join(outDir, filename+encoding.Extension)); err != nil {
+				t.Errorf("Compressed output %s was not written: %v", filename+encoding.Extension, err)
+			}
+		}
+	}
+
+	index, err := os.ReadFile(filepath.Join(outDir, "index.html"))
+	if err != nil {
+		t.Fatalf("Failed to read index.html: %v", err)
+	}
+	if !strings.Contains(string(index), "update mode: static build") {
+		t.Error("index.html does not report the static build mode")
+	}
+}
+
+func TestRunBuildMultipleRoots(t *testing.T) {
+	homeDir := t.TempDir()
+	teamDir := t.TempDir()
+	outDir := filepath.Join(t.TempDir(), "public")
+
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	writeVaultNotes(t, homeDir, metadata[:1])
+	writeVaultNotes(t, teamDir, metadata[1:])
+
+	config := Config{
+		FeedTitle:    "Static Feed",
+		MaxItems:     50,
+		Roots:        []string{"home=" + homeDir, "team=" + teamDir},
+		CombinedFeed: true,
+	}
+	if err := runBuild(t.Context(), config, []string{"-out", outDir}); err != nil {
+		t.Fatalf("runBuild failed: %v", err)
+	}
+
+	for dir, expected := range map[string][]clippingsfeed.Metadata{
+		"home": metadata[:1],
+		"team": metadata[1:],
+		"":     metadata,
+	} {
+		content, err := os.ReadFile(filepath.Join(outDir, dir, "feed.rss"))
+		if err != nil {
+			t.Fatalf("Failed to read feed of %q: %v", dir, err)
+		}
+		for _, meta := range expected {
+			if !strings.Contains(string(content), meta.Title) {
+				t.Errorf("Feed of %q does not contain %s", dir, meta.Title)
+			}
+		}
+	}
+}
+
+func TestRunBuildSkipsNotifications(t *testing.T) {
+	vaultDir := t.TempDir()
+	stateDir := t.TempDir()
+	outDir := filepath.Join(t.TempDir(), "public")
+	writeVaultNotes(t, vaultDir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+
+	config := Config{
+		FeedTitle:         "Static Feed",
+		FeedLink:          "https://feeds.example.com/",
+		MaxItems:          50,
+		StateDir:          stateDir,
+		Webhooks:          []string{"http://127.0.0.1:1/hook"},
+		WebSubBuiltinHub:  true,
+		ActivityPubUser:   "clippings",
+		ActivityPubObject: "Note",
+		DigestTo:          []string{"reader@example.com"},
+		DigestFrom:        "feed@example.com",
+		DigestSchedule:    "daily",
+		DigestSMTPAddr:    "127.0.0.1:1",
+	}
+	// The second build sees a new clipping, which a running service would notify about
+	for _, title := range []string{"", "Second"} {
+		if title != "" {
+			writeVaultNotes(t, vaultDir, []clippingsfeed.Metadata{{Title: title, Source: "https://example.com/second", Created: time.Now()}})
+		}
+		if err := runBuild(t.Context(), config, []string{"-target", vaultDir, "-out", outDir}); err != nil {
+			t.Fatalf("runBuild failed: %v", err)
+		}
+	}
+
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		t.Fatalf("Failed to read state dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected no notification state to be written, got %v", entries)
+	}
+	content, err := os.ReadFile(filepath.Join(outDir, "feed.atom"))
+	if err != nil {
+		t.Fatalf("Failed to read feed.atom: %v", err)
+	}
+	if strings.Contains(string(content), "https://feeds.example.com/hub") {
+		t.Error("Expected the static feed not to advertise the built-in hub")
+	}
+}
+
+func TestRunBuildErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "missing vault",
+			args: []string{"-target", filepath.Join(t.TempDir(), "missing"), "-out", t.TempDir()},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-unknown"},
+		},
+		{
+			name: "extra arguments",
+			args: []string{"-out", t.TempDir(), "extra"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := runBuild(t.Context(), Config{TargetDir: t.TempDir()}, tt.args); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestRunBuildArchive(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "backup.zip")
+	outDir := filepath.Join(t.TempDir(), "public")
+
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	w := zip.NewWriter(f)
+	for _, meta := range metadata {
+		entry, err := w.Create("vault/Clippings/" + meta.Title + ".md")
+		if err != nil {
+			t.Fatalf("Failed to add archive entry: %v", err)
+		}
+		if _, err := entry.Write([]byte(createMarkdownContent(meta))); err != nil {
+			t.Fatalf("Failed to write archive entry: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	config := Config{FeedTitle: "Static Feed", MaxItems: 50}
+	if err := runBuild(t.Context(), config, []string{"-target", archive, "-out", outDir}); err != nil {
+		t.Fatalf("runBuild failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(outDir, "feed.json"))
+	if err != nil {
+		t.Fatalf("Failed to read feed.json: %v", err)
+	}
+	for _, meta := range metadata {
+		if !strings.Contains(string(content), meta.Title) {
+			t.Errorf("feed.json does not contain %s", meta.Title)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	digestTimeout    = 30 * time.Second
+	digestRetryDelay = 5 * time.Minute
+)
+
+const defaultDigestText = `{{len .Items}} new clippings in {{.Title}} since {{.Since.Format "2006-01-02 15:04"}}
+{{range .Items}}
+- {{.Title}}{{with .Site}} ({{.}}){{end}}
+  {{.Source}}
+{{- with .Description}}
+  {{.}}
+{{- end}}
+{{end}}
+{{.Link}}
+`
+
+const defaultDigestHTML = `<!DOCTYPE html>
+<html>
+<body>
+<p>{{len .Items}} new clippings in <a href="{{.Link}}">{{.Title}}</a> since {{.Since.Format "2006-01-02 15:04"}}</p>
+<ul>
+{{- range .Items}}
+<li><a href="{{.Source}}">{{.Title}}</a>{{with .Site}} ({{.}}){{end}}{{with .Description}}<br>{{.}}{{end}}</li>
+{{- end}}
+</ul>
+</body>
+</html>
+`
+
+// digestItem is a clipping waiting for the next digest
+type digestItem struct {
+	Vault string `json:"vault,omitempty"`
+	apiItem
+}
+
+// digestData is what the subject and body templates render
+type digestData struct {
+	Title string
+	Link  string
+	Since time.Time
+	Until time.Time
+	Items []digestItem
+}
+
+type digestState struct {
+	clippingTracker
+	// Pending holds the clippings found since the last digest
+	Pending  []digestItem `json:"pending"`
+	LastSent time.Time    `json:"lastSent"`
+}
+
+// Digest mails the clippings added since the last digest to the configured recipients, daily
+// or weekly. The clippings waiting for the next digest and when the last one was sent are saved
+// to a state file across restarts.
+type Digest struct {
+	title     string
+	link      string
+	from      *mail.Address
+	to        []*mail.Address
+	addr      string
+	auth      smtp.Auth
+	interval  time.Duration
+	stateFile string
+
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+
+	mu    sync.Mutex
+	state digestState
+}
+
+// NewDigest creates the digest of config.DigestTo, or returns nil when it has no recipients
+func NewDigest(config Config) (*Digest, error) {
+	if len(config.DigestTo) == 0 {
+		return nil, nil
+	}
+
+	stateFile, err := statePath(config, "the digest", "digest.json")
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Digest{
+		title:     config.FeedTitle,
+		link:      config.FeedLink,
+		addr:      config.DigestSMTPAddr,
+		stateFile: stateFile,
+	}
+
+	switch config.DigestSchedule {
+	case "daily":
+		d.interval = 24 * time.Hour
+	case "weekly":
+		d.interval = 7 * 24 * time.Hour
+	default:
+		return nil, fmt.Errorf("invalid digest schedule %q: expected daily or weekly", config.DigestSchedule)
+	}
+
+	if d.from, err = mail.ParseAddress(config.DigestFrom); err != nil {
+		return nil, fmt.Errorf("invalid digest sender %q: %w", config.DigestFrom, err)
+	}
+	for _, to := range config.DigestTo {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid digest recipient %q: %w", to, err)
+		}
+		d.to = append(d.to, address)
+	}
+
+	host, _, err := net.SplitHostPort(d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %w", d.addr, err)
+	}
+	if config.DigestSMTPUser != "" {
+		d.auth = smtp.PlainAuth("", config.DigestSMTPUser, config.DigestSMTPPassword, host)
+	}
+
+	if d.subject, err = template.New("subject").Parse(config.DigestSubject); err != nil {
+		return nil, fmt.Errorf("failed to parse digest subject: %w", err)
+	}
+	text, err := readDigestTemplate(config.DigestTextTemplate, defaultDigestText)
+	if err != nil {
+		return nil, err
+	}
+	if d.text, err = template.New("text").Parse(text); err != nil {
+		return nil, fmt.Errorf("failed to parse digest text template: %w", err)
+	}
+	html, err := readDigestTemplate(config.DigestHTMLTemplate, defaultDigestHTML)
+	if err != nil {
+		return nil, err
+	}
+	if d.html, err = htmltemplate.New("html").Parse(html); err != nil {
+		return nil, fmt.Errorf("failed to parse digest HTML template: %w", err)
+	}
+
+	found, err := loadState(d.stateFile, &d.state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load digest state: %w", err)
+	}
+	if !found {
+		// The first digest covers what is added from now on
+		d.state.LastSent = time.Now()
+	}
+
+	return d, nil
+}
+
+// readDigestTemplate returns the content of filename, or fallback without one
+func readDigestTemplate(filename, fallback string) (string, error) {
+	if filename == "" {
+		return fallback, nil
+	}
+	text, err := os.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read digest template: %w", err)
+	}
+	return string(text), nil
+}
+
+// save writes the state file; the caller holds d.mu
+func (d *Digest) save() {
+	if err := saveState(d.stateFile, d.state); err != nil {
+		slog.Error("Failed to save digest state", "error", err)
+	}
+}
+
+// Notify adds the clippings added to vault in gen to the next digest
+func (d *Digest) Notify(vault string, gen *Generation) {
+	if gen.api == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	added, changed := d.state.add(vault, gen.api.items)
+	if !changed {
+		return
+	}
+	for _, item := range added {
+		d.state.Pending = append(d.state.Pending, digestItem{Vault: vault, apiItem: item})
+	}
+	d.save()
+}
+
+// Run sends a digest every interval until ctx is done. Nothing is sent when no clippings were
+// added; a digest that fails to send is retried later with the clippings added meanwhile.
+func (d *Digest) Run(ctx context.Context) {
+	d.mu.Lock()
+	next := d.state.LastSent.Add(d.interval)
+	d.mu.Unlock()
+
+	timer := time.NewTimer(max(time.Until(next), 0))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wait := d.interval
+		if err := d.send(time.Now()); err != nil {
+			slog.Error("Failed to send digest", "error", err)
+			wait = min(digestRetryDelay, d.interval)
+		}
+		timer.Reset(wait)
+	}
+}
+
+// send mails the pending clippings as the digest up to now
+func (d *Digest) send(now time.Time) error {
+	d.mu.Lock()
+	data := digestData{
+		Title: d.title,
+		Link:  d.link,
+		Since: d.state.LastSent,
+		Until: now,
+		Items: slices.Clone(d.state.Pending),
+	}
+	d.mu.Unlock()
+
+	if len(data.Items) > 0 {
+		slices.SortStableFunc(data.Items, func(a, b digestItem) int { return b.Created.Compare(a.Created) })
+		message, err := d.message(data)
+		if err != nil {
+			return err
+		}
+		if err := d.sendMail(message); err != nil {
+			return err
+		}
+		slog.Info("Sent digest", "items", len(data.Items), "recipients", len(d.to))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Clippings added while sending wait for the next digest
+	d.state.Pending = slices.DeleteFunc(d.state.Pending, func(item digestItem) bool {
+		return slices.ContainsFunc(data.Items, func(sent digestItem) bool { return sent.Source == item.Source })
+	})
+	d.state.LastSent = now
+	d.save()
+	return nil
+}
+
+// message renders data as a multipart/alternative mail with a plain text and an HTML part
+func (d *Digest) message(data digestData) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := d.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("failed to render digest subject: %w", err)
+	}
+	if err := d.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("failed to render digest text: %w", err)
+	}
+	if err := d.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("failed to render digest HTML: %w", err)
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{{"text/plain", text.Bytes()}, {"text/html", html.Bytes()}} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create message part: %w", err)
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, fmt.Errorf("failed to encode message part: %w", err)
+		}
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode message part: %w", err)
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close message: %w", err)
+	}
+
+	to := make([]string, 0, len(d.to))
+	for _, address := range d.to {
+		to = append(to, address.String())
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	_, domain, _ := strings.Cut(d.from.Address, "@")
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", d.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&message, "Date: %s\r\n", data.Until.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+// sendMail delivers message to the recipients over SMTP, upgrading to TLS when the server
+// offers STARTTLS
+func (d *Digest) sendMail(message []byte) error {
+	conn, err := net.DialTimeout("tcp", d.addr, digestTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(digestTimeout))
+
+	host, _, _ := net.SplitHostPort(d.addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if d.auth != nil {
+		if err := client.Auth(d.auth); err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+	if err := client.Mail(d.from.Address); err != nil {
+		return fmt.Errorf("failed to set sender: %w", err)
+	}
+	for _, to := range d.to {
+		if err := client.Rcpt(to.Address); err != nil {
+			return fmt.Errorf("failed to add recipient %s: %w", to.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpStandIn is a minimal SMTP server recording the messages sent to it. While reject is
+// set, it refuses them.
+type smtpStandIn struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	reject   bool
+	messages []smtpMessage
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &smtpStandIn{listener: listener}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	text := textproto.NewConn(conn)
+	defer func() { _ = text.Close() }()
+
+	var message smtpMessage
+	_ = text.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			_ = text.PrintfLine("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = smtpMessage{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			_ = text.PrintfLine("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = append(message.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			_ = text.PrintfLine("250 OK")
+		case command == "DATA":
+			_ = text.PrintfLine("354 Go ahead")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			message.data = strings.Join(lines, "\r\n")
+
+			s.mu.Lock()
+			reject := s.reject
+			if !reject {
+				s.messages = append(s.messages, message)
+			}
+			s.mu.Unlock()
+			if reject {
+				_ = text.PrintfLine("451 Try again later")
+			} else {
+				_ = text.PrintfLine("250 Queued")
+			}
+		case command == "QUIT":
+			_ = text.PrintfLine("221 Bye")
+			return
+		default:
+			_ = text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpStandIn) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *smtpStandIn) setReject(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = reject
+}
+
+// readDigest parses a sent digest into its subject and its parts by content type
+func readDigest(t *testing.T, data string) (string, map[string]string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to parse message: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("Failed to decode subject: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected a multipart/alternative message, got %q: %v", mediaType, err)
+	}
+
+	parts := make(map[string]string)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		// Quoted-printable encodes line breaks as CRLF
+		parts[contentType] = strings.ReplaceAll(string(content), "\r\n", "\n")
+	}
+	return subject, parts
+}
+
+func newDigestTestConfig(t *testing.T, dir string, server *smtpStandIn) Config {
+	t.Helper()
+
+	return Config{
+		TargetDir:      dir,
+		MaxItems:       50,
+		FeedTitle:      "Clippings",
+		FeedLink:       "https://feeds.example.com/",
+		DigestTo:       []string{"Alice <alice@example.com>", "bob@example.com"},
+		DigestFrom:     "Clippings <clippings@example.com>",
+		DigestSMTPAddr: server.listener.Addr().String(),
+		DigestSchedule: "daily",
+		DigestSubject:  "{{.Title}}: {{len .Items}} new clippings",
+		StateDir:       t.TempDir(),
+	}
+}
+
+func TestDigest(t *testing.T) {
+	server := newSMTPStandIn(t)
+	dir := t.TempDir()
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+	config := newDigestTestConfig(t, dir, server)
+
+	vaults, err := NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "Second", Source: "https://example.com/second",
+		Description: "Worth <reading>", Created: time.Now()}})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	vaults.digest.interval = 50 * time.Millisecond
+	vaults.StartDigest(ctx)
+	waitFor(t, func() bool { return len(server.received()) == 1 })
+
+	message := server.received()[0]
+	if message.from != "clippings@example.com" || strings.Join(message.to, " ") != "alice@example.com bob@example.com" {
+		t.Errorf("Unexpected envelope %s -> %v", message.from, message.to)
+	}
+	subject, parts := readDigest(t, message.data)
+	if subject != "Clippings: 1 new clippings" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	if text := parts["text/plain"]; !strings.Contains(text, "- Second\n  https://example.com/second\n  Worth <reading>") || strings.Contains(text, "First") {
+		t.Errorf("Unexpected text part %q", text)
+	}
+	if html := parts["text/html"]; !strings.Contains(html, `<a href="https://example.com/second">Second</a><br>Worth &lt;reading&gt;`) {
+		t.Errorf("Unexpected HTML part %q", html)
+	}
+
+	// Nothing is sent while no clippings are added
+	time.Sleep(200 * time.Millisecond)
+	if got := len(server.received()); got != 1 {
+		t.Errorf("Expected no empty digests, got %d messages", got)
+	}
+
+	cancel()
+	if err := vaults.Shutdown(t.Context()); err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+
+	restarted, err := NewDigest(config)
+	if err != nil {
+		t.Fatalf("NewDigest failed: %v", err)
+	}
+	if len(restarted.state.Pending) != 0 || time.Since(restarted.state.LastSent) > time.Second {
+		t.Errorf("Expected the sent digest to be recorded, got %+v", restarted.state)
+	}
+}
+
+func TestDigestRetry(t *testing.T) {
+	server := newSMTPStandIn(t)
+	server.setReject(true)
+	dir := t.TempDir()
+	config := newDigestTestConfig(t, dir, server)
+
+	vaults, err := NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	lastSent := vaults.digest.state.LastSent
+	if err := vaults.digest.send(time.Now()); err == nil {
+		t.Fatal("Expected the rejected digest to fail")
+	}
+
+	// The clippings of a failed digest survive a restart
+	restarted, err := NewDigest(config)
+	if err != nil {
+		t.Fatalf("NewDigest failed: %v", err)
+	}
+	if len(restarted.state.Pending) != 1 || !restarted.state.LastSent.Equal(lastSent) {
+		t.Fatalf("Expected the clipping to stay pending, got %+v", restarted.state)
+	}
+
+	server.setReject(false)
+	if err := restarted.send(time.Now()); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	if got := server.received(); len(got) != 1 || !strings.Contains(got[0].data, "example.com/first") {
+		t.Errorf("Expected the digest to be sent, got %v", got)
+	}
+	if len(restarted.state.Pending) != 0 {
+		t.Errorf("Expected no pending clippings, got %v", restarted.state.Pending)
+	}
+}
+
+func TestDigestTemplates(t *testing.T) {
+	server := newSMTPStandIn(t)
+	templates := t.TempDir()
+	config := newDigestTestConfig(t, t.TempDir(), server)
+	config.DigestSchedule = "weekly"
+	config.DigestSubject = "Weekly: {{range .Items}}{{.Title}} {{end}}"
+	config.DigestTextTemplate = filepath.Join(templates, "digest.txt")
+	config.DigestHTMLTemplate = filepath.Join(templates, "digest.html")
+	if err := os.WriteFile(config.DigestTextTemplate, []byte("{{range .Items}}* {{.Title}} [{{.Vault}}]\n{{end}}"), 0o644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	if err := os.WriteFile(config.DigestHTMLTemplate, []byte("<ol>{{range .Items}}<li>{{.Title}}</li>{{end}}</ol>"), 0o644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	digest, err := NewDigest(config)
+	if err != nil {
+		t.Fatalf("NewDigest failed: %v", err)
+	}
+	if digest.interval != 7*24*time.Hour {
+		t.Errorf("Expected a weekly digest, got %v", digest.interval)
+	}
+	digest.state.Pending = []digestItem{
+		{Vault: "work", apiItem: apiItem{Title: "Older <one>", Source: "https://example.com/older", Created: time.Now().Add(-time.Hour)}},
+		{Vault: "home", apiItem: apiItem{Title: "Newer", Source: "https://example.com/newer", Created: time.Now()}},
+	}
+	if err := digest.send(time.Now()); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	subject, parts := readDigest(t, server.received()[0].data)
+	if subject != "Weekly: Newer Older <one>" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	if text := parts["text/plain"]; text != "* Newer [home]\n* Older <one> [work]\n" {
+		t.Errorf("Unexpected text part %q", text)
+	}
+	if html := parts["text/html"]; html != "<ol><li>Newer</li><li>Older &lt;one&gt;</li></ol>" {
+		t.Errorf("Unexpected HTML part %q", html)
+	}
+}
+
+func TestNewDigestErrors(t *testing.T) {
+	tests := map[string]func(config *Config){
+		"invalid schedule":  func(config *Config) { config.DigestSchedule = "hourly" },
+		"invalid sender":    func(config *Config) { config.DigestFrom = "not an address" },
+		"invalid recipient": func(config *Config) { config.DigestTo = []string{"not an address"} },
+		"invalid address":   func(config *Config) { config.DigestSMTPAddr = "localhost" },
+		"invalid subject":   func(config *Config) { config.DigestSubject = "{{.Title" },
+		"missing template":  func(config *Config) { config.DigestTextTemplate = filepath.Join(t.TempDir(), "missing.txt") },
+		"no state dir":      func(config *Config) { config.StateDir = "" },
+	}
+	for name, modify := range tests {
+		config := Config{
+			DigestTo:       []string{"alice@example.com"},
+			DigestFrom:     "clippings@example.com",
+			DigestSMTPAddr: "localhost:25",
+			DigestSchedule: "daily",
+			StateDir:       t.TempDir(),
+		}
+		modify(&config)
+		if _, err := NewDigest(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if digest, err := NewDigest(Config{}); digest != nil || err != nil {
+		t.Errorf("Expected no digest without recipients, got %v, %v", digest, err)
+	}
+}
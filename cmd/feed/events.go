@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	eventHistoryLimit      = 1000
+	eventHeartbeatInterval = 30 * time.Second
+	eventRetry             = 5 * time.Second
+)
+
+// Types of the events streamed from /events
+const (
+	eventAdded   = "added"
+	eventUpdated = "updated"
+	eventRemoved = "removed"
+	// eventReset tells a client resuming from an event that is no longer in the history to
+	// reload the items, e.g. from /api/items
+	eventReset = "reset"
+)
+
+// clippingEvent reports that an item was added, updated or removed by a regeneration
+type clippingEvent struct {
+	ID   uint64
+	Type string
+	Data []byte
+}
+
+// eventLog keeps the latest events of a store for clients resuming with Last-Event-ID.
+// Event IDs start from the time the log was created, so that an ID from before a restart
+// is never taken for a current one.
+type eventLog struct {
+	mu      sync.Mutex
+	firstID uint64
+	lastID  uint64
+	history []clippingEvent
+	limit   int
+	// appended is closed and replaced whenever events are appended
+	appended chan struct{}
+}
+
+func newEventLog(limit int) *eventLog {
+	start := uint64(time.Now().UnixMilli()) * 1000
+	return &eventLog{
+		firstID:  start + 1,
+		lastID:   start,
+		limit:    limit,
+		appended: make(chan struct{}),
+	}
+}
+
+// record appends the events describing how the items changed from previous to current
+func (l *eventLog) record(previous, current *Generation) {
+	if previous == nil || previous.api == nil || current.api == nil {
+		// The first generation is the initial state, not a change
+		return
+	}
+
+	type change struct {
+		kind string
+		body any
+	}
+	var changes []change
+	for _, item := range current.api.items {
+		i, ok := previous.api.byID[item.ID]
+		switch {
+		case !ok:
+			changes = append(changes, change{eventAdded, item})
+		case !reflect.DeepEqual(previous.api.items[i], item):
+			changes = append(changes, change{eventUpdated, item})
+		}
+	}
+	for _, item := range previous.api.items {
+		if _, ok := current.api.byID[item.ID]; !ok {
+			changes = append(changes, change{eventRemoved, map[string]string{"id": item.ID, "source": item.Source}})
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, c := range changes {
+		data, err := json.Marshal(c.body)
+		if err != nil {
+			slog.Error("Failed to encode event", "type", c.kind, "error", err)
+			continue
+		}
+		l.lastID++
+		l.history = append(l.history, clippingEvent{ID: l.lastID, Type: c.kind, Data: data})
+	}
+	if overflow := len(l.history) - l.limit; overflow > 0 {
+		l.history = append(l.history[:0:0], l.history[overflow:]...)
+	}
+	if len(l.history) > 0 {
+		l.firstID = l.history[0].ID
+	} else {
+		l.firstID = l.lastID + 1
+	}
+
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// since returns the events after lastID and a channel closed when more are appended. ok is
+// false when events after lastID were dropped from the history or lastID is unknown.
+func (l *eventLog) since(lastID uint64) (events []clippingEvent, last uint64, ok bool, appended <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if lastID+1 < l.firstID || lastID > l.lastID {
+		return nil, l.lastID, false, l.appended
+	}
+	for _, event := range l.history {
+		if event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+	return events, l.lastID, true, l.appended
+}
+
+// latest returns the ID of the last event
+func (l *eventLog) latest() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID
+}
+
+// registerEvents adds /events, a Server-Sent Events stream of the changes to the items of
+// store. Each event carries the item as in /api/items, or its id and source once removed.
+func registerEvents(mux *http.ServeMux, store *Store) {
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		header := w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		// A client resumes after the last event it received, anything else gets a reset
+		last := store.events.latest()
+		if resume := r.Header.Get("Last-Event-ID"); resume != "" {
+			last, _ = strconv.ParseUint(resume, 10, 64)
+		}
+
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds()); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			slog.Error("Streaming is not supported", "error", err)
+			return
+		}
+
+		heartbeat := time.NewTicker(eventHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			events, latest, ok, appended := store.events.since(last)
+			if !ok {
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", latest, eventReset); err != nil {
+					return
+				}
+				last = latest
+			}
+			for _, event := range events {
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+					return
+				}
+				last = event.ID
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-appended:
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// openEvents connects to the event stream at url, resuming after lastEventID when set
+func openEvents(t *testing.T, url, lastEventID string) *bufio.Reader {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", url, err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent returns the next event of the stream, skipping comments and retry hints
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+
+	var event sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if event.event != "" {
+				return event
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.event = value
+		case "data":
+			event.data = value
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	kept := clippingsfeed.Metadata{Title: "Kept", Source: "https://example.com/kept", Created: created}
+	edited := clippingsfeed.Metadata{Title: "Edited", Source: "https://example.com/edited", Created: created}
+	removed := clippingsfeed.Metadata{Title: "Removed", Source: "https://example.com/removed", Created: created}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{kept, edited, removed})
+
+	vaults, err := NewVaultSet(Config{TargetDir: dir, MaxItems: 50})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	// Closed after the streams, which are cleaned up first
+	server := httptest.NewServer(vaults.Handler())
+	t.Cleanup(server.Close)
+
+	stream := openEvents(t, server.URL+"/events", "")
+
+	added := clippingsfeed.Metadata{Title: "Added", Source: "https://example.com/added", Created: created.Add(time.Hour)}
+	edited.Title = "Edited again"
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{added})
+	if err := os.WriteFile(filepath.Join(dir, "Edited.md"), []byte(createMarkdownContent(edited)), 0644); err != nil {
+		t.Fatalf("Failed to edit note: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "Removed.md")); err != nil {
+		t.Fatalf("Failed to remove note: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	var events []sseEvent
+	for range 3 {
+		events = append(events, readEvent(t, stream))
+	}
+	expected := []struct {
+		event  string
+		source string
+		title  string
+	}{
+		{eventAdded, added.Source, "Added"},
+		{eventUpdated, edited.Source, "Edited again"},
+		{eventRemoved, removed.Source, ""},
+	}
+	for i, event := range events {
+		var data map[string]any
+		if err := json.Unmarshal([]byte(event.data), &data); err != nil {
+			t.Fatalf("Event %d has invalid data %q: %v", i, event.data, err)
+		}
+		if event.event != expected[i].event || data["source"] != expected[i].source || data["id"] != apiItemID(expected[i].source) {
+			t.Errorf("Expected %s event for %s, got %s %v", expected[i].event, expected[i].source, event.event, data)
+		}
+		if expected[i].title != "" && data["title"] != expected[i].title {
+			t.Errorf("Expected title %q, got %v", expected[i].title, data["title"])
+		}
+	}
+
+	// Resuming replays the events after the last one received
+	resumed := openEvents(t, server.URL+"/events", events[0].id)
+	for _, want := range events[1:] {
+		if got := readEvent(t, resumed); got != want {
+			t.Errorf("Expected replayed %v, got %v", want, got)
+		}
+	}
+
+	// An unknown event ID, e.g. from before a restart, resets the client
+	reset := openEvents(t, server.URL+"/events", "1")
+	if got := readEvent(t, reset); got.event != eventReset || got.id != events[2].id {
+		t.Errorf("Expected a reset to %s, got %v", events[2].id, got)
+	}
+}
+
+func TestEventLogHistoryLimit(t *testing.T) {
+	log := newEventLog(2)
+	start := log.latest()
+
+	previous := NewGeneration(time.Now(), nil)
+	previous.api = newAPIIndex(nil, false)
+	current := NewGeneration(time.Now(), nil)
+	current.api = newAPIIndex([]clippingsfeed.Metadata{
+		{Title: "A", Source: "https://example.com/a"},
+		{Title: "B", Source: "https://example.com/b"},
+		{Title: "C", Source: "https://example.com/c"},
+	}, false)
+	log.record(previous, current)
+
+	if latest := log.latest(); latest != start+3 {
+		t.Errorf("Expected 3 events, got %d", latest-start)
+	}
+	if _, _, ok, _ := log.since(start); ok {
+		t.Error("Expected the first event to be dropped from the history")
+	}
+	events, _, ok, _ := log.since(start + 1)
+	if !ok || len(events) != 2 || events[0].ID != start+2 {
+		t.Errorf("Expected the last 2 events, got %v (ok=%v)", events, ok)
+	}
+	if _, _, ok, _ := log.since(start + 4); ok {
+		t.Error("Expected an event ID from the future to be unknown")
+	}
+
+	// The first generation is not reported as added items
+	first := newEventLog(10)
+	before := first.latest()
+	first.record(nil, current)
+	if first.latest() != before {
+		t.Errorf("Expected no events for the first generation, got %d", first.latest()-before)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+var contentTypes = map[string]string{
+	".rss":  "application/rss+xml; charset=utf-8",
+	".atom": "application/atom+xml; charset=utf-8",
+	".json": "application/feed+json; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+}
+
+// contentTypeFor returns the Content-Type for a generated output, judged by its uncompressed name
+func contentTypeFor(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// newStoreHandler serves the outputs of store along with the JSON API, the search page and
+// the event stream over its metadata
+func newStoreHandler(store *Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", store)
+	registerAPI(mux, store)
+	registerSearch(mux, store)
+	registerEvents(mux, store)
+	return mux
+}
+
+// ServeHTTP serves the outputs of the current generation, preferring a pre-compressed variant
+// when the client accepts one
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	gen := s.Current()
+	if gen == nil {
+		http.Error(w, "feeds are not generated yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+
+	output, ok := gen.Outputs[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	serveOutput(w, r, output, gen.Created)
+}
+
+// serveOutput serves output, last modified at modTime, preferring a pre-compressed variant
+// when the client accepts one
+func serveOutput(w http.ResponseWriter, r *http.Request, output *Output, modTime time.Time) {
+	header := w.Header()
+	if output.ContentType != "" {
+		header.Set("Content-Type", output.ContentType)
+	}
+	if output.Link != "" {
+		header.Set("Link", output.Link)
+	}
+
+	var available []clippingsfeed.Encoding
+	for _, encoding := range clippingsfeed.Encodings {
+		if _, ok := output.Encoded[encoding.Name]; ok {
+			available = append(available, encoding)
+		}
+	}
+	if len(available) > 0 {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	body := output.Body
+	etag := output.ETag
+	if encoding, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"), available); ok {
+		body = output.Encoded[encoding.Name]
+		etag += "-" + encoding.Name
+		header.Set("Content-Encoding", encoding.Name)
+	}
+	header.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, output.Name, modTime, bytes.NewReader(body))
+}
+
+// negotiateEncoding picks the available encoding with the highest quality in an Accept-Encoding
+// header. Ties are broken by the order of available.
+func negotiateEncoding(acceptEncoding string, available []clippingsfeed.Encoding) (clippingsfeed.Encoding, bool) {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		token, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		token = strings.ToLower(strings.TrimSpace(token))
+		if token == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				quality = q
+			}
+		}
+		qualities[token] = quality
+	}
+
+	var best clippingsfeed.Encoding
+	bestQuality := 0.0
+	for _, encoding := range available {
+		quality, ok := qualities[encoding.Name]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+
+	return best, bestQuality > 0
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		expected       string
+	}{
+		{name: "empty", acceptEncoding: "", expected: ""},
+		{name: "gzip only", acceptEncoding: "gzip", expected: "gzip"},
+		{name: "prefers brotli", acceptEncoding: "gzip, deflate, br", expected: "br"},
+		{name: "quality wins", acceptEncoding: "br;q=0.5, gzip;q=0.8", expected: "gzip"},
+		{name: "refused", acceptEncoding: "br;q=0, gzip;q=0", expected: ""},
+		{name: "wildcard", acceptEncoding: "*", expected: "br"},
+		{name: "wildcard with refusal", acceptEncoding: "br;q=0, *", expected: "gzip"},
+		{name: "identity", acceptEncoding: "identity", expected: ""},
+		{name: "case insensitive", acceptEncoding: "GZIP", expected: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, ok := negotiateEncoding(tt.acceptEncoding, clippingsfeed.Encodings)
+			if tt.expected == "" {
+				if ok {
+					t.Errorf("Expected no encoding, got %s", encoding.Name)
+				}
+				return
+			}
+			if !ok || encoding.Name != tt.expected {
+				t.Errorf("Expected %s, got %s (ok=%v)", tt.expected, encoding.Name, ok)
+			}
+		})
+	}
+}
+
+func TestStoreServeHTTP(t *testing.T) {
+	gen := NewGeneration(time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC), nil)
+	for name, content := range map[string]string{
+		"feed.rss":   "<rss></rss>",
+		"index.html": "<html></html>",
+	} {
+		if err := gen.Add(name, []byte(content)); err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+	}
+	gen.Outputs["plain.json"] = &Output{Name: "plain.json", ContentType: contentTypeFor("plain.json"), Body: []byte("{}"), ETag: "plain"}
+
+	store := NewStore()
+	store.Publish(gen)
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		expectedStatus int
+		expectedBody   string
+		expectedEnc    string
+		expectedType   string
+		expectedVary   bool
+	}{
+		{
+			name:           "brotli",
+			path:           "/feed.rss",
+			acceptEncoding: "gzip, br",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<rss></rss>",
+			expectedEnc:    "br",
+			expectedType:   "application/rss+xml; charset=utf-8",
+			expectedVary:   true,
+		},
+		{
+			name:           "gzip",
+			path:           "/feed.rss",
+			acceptEncoding: "gzip",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<rss></rss>",
+			expectedEnc:    "gzip",
+			expectedType:   "application/rss+xml; charset=utf-8",
+			expectedVary:   true,
+		},
+		{
+			name:           "uncompressed",
+			path:           "/feed.rss",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<rss></rss>",
+			expectedType:   "application/rss+xml; charset=utf-8",
+			expectedVary:   true,
+		},
+		{
+			name:           "index",
+			path:           "/",
+			acceptEncoding: "gzip",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<html></html>",
+			expectedEnc:    "gzip",
+			expectedType:   "text/html; charset=utf-8",
+			expectedVary:   true,
+		},
+		{
+			name:           "no variants",
+			path:           "/plain.json",
+			acceptEncoding: "gzip, br",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "{}",
+			expectedType:   "application/feed+json; charset=utf-8",
+		},
+		{
+			name:           "not found",
+			path:           "/missing.rss",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "path traversal",
+			path:           "/../feed.rss",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<rss></rss>",
+			expectedType:   "application/rss+xml; charset=utf-8",
+			expectedVary:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+
+			store.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if body := decodeBody(t, rec); body != tt.expectedBody {
+				t.Errorf("Expected body %q, got %q", tt.expectedBody, body)
+			}
+			if enc := rec.Header().Get("Content-Encoding"); enc != tt.expectedEnc {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.expectedEnc, enc)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != tt.expectedType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.expectedType, contentType)
+			}
+			if vary := rec.Header().Get("Vary") == "Accept-Encoding"; vary != tt.expectedVary {
+				t.Errorf("Expected Vary header presence %v, got %v", tt.expectedVary, vary)
+			}
+		})
+	}
+}
+
+func TestStoreServeHTTPConditional(t *testing.T) {
+	gen := NewGeneration(time.Now(), nil)
+	if err := gen.Add("feed.rss", []byte("<rss></rss>")); err != nil {
+		t.Fatalf("Failed to add feed: %v", err)
+	}
+	store := NewStore()
+	store.Publish(gen)
+
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.rss", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag header")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/feed.rss", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	store.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d, got %d", http.StatusNotModified, rec.Code)
+	}
+}
+
+func TestStoreServeHTTPMethodNotAllowed(t *testing.T) {
+	store := NewStore()
+	store.Publish(NewGeneration(time.Now(), nil))
+
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/feed.rss", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestStoreServeHTTPNotGenerated(t *testing.T) {
+	store := NewStore()
+
+	rec := httptest.NewRecorder()
+	store.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.rss", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
+
+// decodeBody returns the response body with its Content-Encoding removed
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var r io.Reader = rec.Body
+	switch rec.Header().Get("Content-Encoding") {
+	case "gzip":
+		gr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Failed to read gzip body: %v", err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(rec.Body)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to decode body: %v", err)
+	}
+	return string(body)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpSignatureMaxSkew is how far the Date of a signed request may be from now, as Mastodon
+// allows
+const httpSignatureMaxSkew = 12 * time.Hour
+
+// signRequest signs req with key as keyID, the way Mastodon-compatible servers expect it: an
+// rsa-sha256 HTTP signature over the request target, host and date, and for a request with a
+// body over its SHA-256 digest as well
+func signRequest(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "digest")
+	}
+
+	hashed := sha256.Sum256([]byte(signingString(req, headers)))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
+
+// signingString builds the string an HTTP signature covers from the headers of req
+func signingString(req *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, header := range headers {
+		switch header {
+		case "(request-target)":
+			lines = append(lines, "(request-target): "+strings.ToLower(req.Method)+" "+req.URL.RequestURI())
+		case "host":
+			lines = append(lines, "host: "+req.Host)
+		default:
+			lines = append(lines, header+": "+strings.Join(req.Header.Values(header), ", "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// httpSignature is a parsed Signature header
+type httpSignature struct {
+	keyID     string
+	headers   []string
+	signature []byte
+}
+
+func parseHTTPSignature(header string) (*httpSignature, error) {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		params[key] = strings.Trim(value, `"`)
+	}
+
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, errors.New("missing keyId or signature")
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && algorithm != "rsa-sha256" && algorithm != "hs2019" {
+		return nil, fmt.Errorf("unsupported algorithm %s", algorithm)
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	headers := []string{"date"}
+	if params["headers"] != "" {
+		headers = strings.Fields(strings.ToLower(params["headers"]))
+	}
+	return &httpSignature{keyID: params["keyId"], headers: headers, signature: signature}, nil
+}
+
+// verifyRequest checks the HTTP signature of req, which carried body, and returns the ID of
+// the key it was signed with. publicKey looks up the key for its ID. The signature has to
+// cover the request target, the date, which has to be recent, and the digest of body.
+func verifyRequest(req *http.Request, body []byte, publicKey func(keyID string) (*rsa.PublicKey, error)) (string, error) {
+	header := req.Header.Get("Signature")
+	if header == "" {
+		return "", errors.New("missing signature")
+	}
+	sig, err := parseHTTPSignature(header)
+	if err != nil {
+		return "", err
+	}
+
+	for _, required := range []string{"(request-target)", "date", "digest"} {
+		if !strings.Contains(" "+strings.Join(sig.headers, " ")+" ", " "+required+" ") {
+			return "", fmt.Errorf("signature does not cover %s", required)
+		}
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return "", fmt.Errorf("invalid date: %w", err)
+	}
+	if skew := time.Since(date); skew > httpSignatureMaxSkew || skew < -httpSignatureMaxSkew {
+		return "", fmt.Errorf("date %s is too far from now", req.Header.Get("Date"))
+	}
+	sum := sha256.Sum256(body)
+	if req.Header.Get("Digest") != "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]) {
+		return "", errors.New("digest does not match the body")
+	}
+
+	key, err := publicKey(sig.keyID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get key %s: %w", sig.keyID, err)
+	}
+	hashed := sha256.Sum256([]byte(signingString(req, sig.headers)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig.signature); err != nil {
+		return "", errors.New("invalid signature")
+	}
+	return sig.keyID, nil
+}
+
+// encodePublicKey returns key as a PEM encoded PKIX public key
+func encodePublicKey(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// decodePublicKey parses a PEM encoded PKIX or PKCS #1 RSA public key
+func decodePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM data in public key")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return rsaKey, nil
+}
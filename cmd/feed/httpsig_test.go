@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPSignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	pemKey, err := encodePublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("encodePublicKey failed: %v", err)
+	}
+	publicKey := func(keyID string) (*rsa.PublicKey, error) {
+		if keyID != "https://example.com/actor#main-key" {
+			return nil, errors.New("unknown key")
+		}
+		return decodePublicKey(pemKey)
+	}
+
+	body := []byte(`{"type":"Follow"}`)
+	signed := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "https://feeds.example.com/ap/inbox", bytes.NewReader(body))
+		if err := signRequest(req, body, "https://example.com/actor#main-key", key); err != nil {
+			t.Fatalf("signRequest failed: %v", err)
+		}
+		return req
+	}
+
+	keyID, err := verifyRequest(signed(), body, publicKey)
+	if err != nil || keyID != "https://example.com/actor#main-key" {
+		t.Fatalf("Expected the signature to verify, got %q, %v", keyID, err)
+	}
+
+	tests := map[string]struct {
+		modify func(req *http.Request)
+		body   []byte
+	}{
+		"tampered body": {body: []byte(`{"type":"Undo"}`)},
+		"stale date": {modify: func(req *http.Request) {
+			req.Header.Set("Date", time.Now().Add(-24*time.Hour).UTC().Format(http.TimeFormat))
+		}},
+		"other target":      {modify: func(req *http.Request) { req.URL.Path = "/ap/outbox" }},
+		"missing signature": {modify: func(req *http.Request) { req.Header.Del("Signature") }},
+		"unknown key": {modify: func(req *http.Request) {
+			req.Header.Set("Signature", `keyId="https://example.com/other#main-key",algorithm="rsa-sha256",headers="(request-target) host date digest",signature="AAAA"`)
+		}},
+	}
+	for name, test := range tests {
+		req := signed()
+		if test.modify != nil {
+			test.modify(req)
+		}
+		verifyBody := body
+		if test.body != nil {
+			verifyBody = test.body
+		}
+		if _, err := verifyRequest(req, verifyBody, publicKey); err == nil {
+			t.Errorf("%s: expected the signature to be rejected", name)
+		}
+	}
+}
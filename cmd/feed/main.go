@@ -1,25 +1,60 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/caarlos0/env/v11"
 )
 
 type Config struct {
-	TargetDir       string        `env:"FEED_TARGET_DIR" envDefault:"./"`
-	Port            string        `env:"FEED_PORT" envDefault:"8080"`
-	FeedTitle       string        `env:"FEED_TITLE" envDefault:"Obsidian Clippings Feed"`
-	FeedLink        string        `env:"FEED_LINK" envDefault:"http://localhost:8080"`
-	FeedDesc        string        `env:"FEED_DESC" envDefault:"RSS feed from Obsidian clippings"`
-	FeedAuthor      string        `env:"FEED_AUTHOR" envDefault:"Obsidian User"`
-	MaxItems        int           `env:"FEED_MAX_ITEMS" envDefault:"50"`
-	DebounceDelay   time.Duration `env:"FEED_DEBOUNCE_DELAY" envDefault:"10s"`
-	HideDescription bool          `env:"FEED_HIDE_DESCRIPTION" envDefault:"true"`
+	TargetDir          string        `env:"FEED_TARGET_DIR" envDefault:"./"`
+	Port               string        `env:"FEED_PORT" envDefault:"8080"`
+	FeedTitle          string        `env:"FEED_TITLE" envDefault:"Obsidian Clippings Feed"`
+	FeedLink           string        `env:"FEED_LINK" envDefault:"http://localhost:8080"`
+	FeedDesc           string        `env:"FEED_DESC" envDefault:"RSS feed from Obsidian clippings"`
+	FeedAuthor         string        `env:"FEED_AUTHOR" envDefault:"Obsidian User"`
+	MaxItems           int           `env:"FEED_MAX_ITEMS" envDefault:"50"`
+	DebounceDelay      time.Duration `env:"FEED_DEBOUNCE_DELAY" envDefault:"10s"`
+	DebounceMaxWait    time.Duration `env:"FEED_DEBOUNCE_MAX_WAIT" envDefault:"1m"`
+	HideDescription    bool          `env:"FEED_HIDE_DESCRIPTION" envDefault:"true"`
+	ExportDir          string        `env:"FEED_EXPORT_DIR"`
+	StateDir           string        `env:"FEED_STATE_DIR"`
+	ShutdownTimeout    time.Duration `env:"FEED_SHUTDOWN_TIMEOUT" envDefault:"10s"`
+	ReconcileInterval  time.Duration `env:"FEED_RECONCILE_INTERVAL" envDefault:"1h"`
+	WatcherBackend     string        `env:"FEED_WATCHER" envDefault:"fsnotify"`
+	PollInterval       time.Duration `env:"FEED_POLL_INTERVAL" envDefault:"30s"`
+	Ignore             []string      `env:"FEED_IGNORE"`
+	FollowSymlinks     bool          `env:"FEED_FOLLOW_SYMLINKS" envDefault:"false"`
+	Roots              []string      `env:"FEED_ROOTS"`
+	CombinedFeed       bool          `env:"FEED_COMBINED_FEED" envDefault:"false"`
+	LiveSyncPassphrase string        `env:"FEED_LIVESYNC_PASSPHRASE"`
+	Webhooks           []string      `env:"FEED_WEBHOOKS"`
+	WebhookTemplate    string        `env:"FEED_WEBHOOK_TEMPLATE"`
+	WebhookContentType string        `env:"FEED_WEBHOOK_CONTENT_TYPE" envDefault:"application/json"`
+	WebhookMaxAttempts int           `env:"FEED_WEBHOOK_MAX_ATTEMPTS" envDefault:"5"`
+	WebhookRetryDelay  time.Duration `env:"FEED_WEBHOOK_RETRY_DELAY" envDefault:"5s"`
+	WebSubHub          string        `env:"FEED_WEBSUB_HUB"`
+	WebSubBuiltinHub   bool          `env:"FEED_WEBSUB_BUILTIN_HUB" envDefault:"false"`
+	ActivityPubUser    string        `env:"FEED_ACTIVITYPUB_USER"`
+	ActivityPubObject  string        `env:"FEED_ACTIVITYPUB_OBJECT" envDefault:"Note"`
+	DigestTo           []string      `env:"FEED_DIGEST_TO"`
+	DigestFrom         string        `env:"FEED_DIGEST_FROM"`
+	DigestSMTPAddr     string        `env:"FEED_DIGEST_SMTP_ADDR" envDefault:"localhost:25"`
+	DigestSMTPUser     string        `env:"FEED_DIGEST_SMTP_USER"`
+	DigestSMTPPassword string        `env:"FEED_DIGEST_SMTP_PASSWORD"`
+	DigestSchedule     string        `env:"FEED_DIGEST_SCHEDULE" envDefault:"daily"`
+	DigestSubject      string        `env:"FEED_DIGEST_SUBJECT" envDefault:"{{.Title}}: {{len .Items}} new clippings"`
+	DigestTextTemplate string        `env:"FEED_DIGEST_TEXT_TEMPLATE"`
+	DigestHTMLTemplate string        `env:"FEED_DIGEST_HTML_TEMPLATE"`
 }
 
 func main() {
@@ -39,49 +74,95 @@ func main() {
 		os.Exit(1)
 	}
 
-	tmpDir, err := os.MkdirTemp("", "obsidian-feed-*")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		err = runBuild(ctx, config, os.Args[2:])
+	} else {
+		err = serve(ctx, config)
+	}
+	stop()
+
 	if err != nil {
-		slog.Error("Failed to create temp directory", "error", err)
+		slog.Error("Feed command failed", "error", err)
 		os.Exit(1)
 	}
-	defer func() {
-		if err := os.RemoveAll(tmpDir); err != nil {
-			slog.Warn("Failed to remove temp directory", "error", err, "tmpDir", tmpDir)
-		}
-	}()
-
-	slog.Info("Created temp directory", "tmpDir", tmpDir)
-
-	generator := NewFeedGenerator(config, tmpDir)
+}
 
-	if err := generator.GenerateFeeds(); err != nil {
-		slog.Error("Failed to generate initial feeds", "error", err)
-		os.Exit(1)
+// serve runs the feed server until ctx is done, then shuts the HTTP server and the
+// file watcher down within config.ShutdownTimeout
+func serve(ctx context.Context, config Config) error {
+	vaults, err := NewVaultSet(config)
+	if err != nil {
+		return err
 	}
 
-	indexHTML := filepath.Join(tmpDir, "index.html")
-	if err := generator.GenerateIndexHTML(indexHTML); err != nil {
-		slog.Error("Failed to generate index.html", "error", err, "file", indexHTML)
-		os.Exit(1)
+	if err := vaults.GenerateFeeds(ctx); err != nil {
+		return fmt.Errorf("failed to generate initial feeds: %w", err)
 	}
 
-	if err := generator.StartFileWatcher(); err != nil {
-		slog.Error("Failed to start file watcher", "error", err)
-		os.Exit(1)
+	if err := vaults.StartFileWatchers(ctx); err != nil {
+		return fmt.Errorf("failed to start file watcher: %w", err)
 	}
+	vaults.StartWebhooks(ctx)
+	vaults.StartDigest(ctx)
 
-	fs := http.FileServer(http.Dir(tmpDir))
-	http.Handle("/", fs)
+	server := &http.Server{
+		Addr:              ":" + config.Port,
+		Handler:           vaults.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// End event streams once shutting down, Shutdown would wait for them otherwise
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
 
 	slog.Info("Starting feed server",
 		"port", config.Port,
-		"watchDir", config.TargetDir,
-		"serveDir", tmpDir,
+		"watchDir", redactVault(config.TargetDir),
+		"roots", redactRoots(config.Roots),
+		"combinedFeed", config.CombinedFeed,
+		"exportDir", config.ExportDir,
+		"stateDir", config.StateDir,
 		"debounceDelay", config.DebounceDelay,
+		"debounceMaxWait", config.DebounceMaxWait,
+		"reconcileInterval", config.ReconcileInterval,
+		"watcher", config.WatcherBackend,
+		"followSymlinks", config.FollowSymlinks,
+		"webhooks", len(config.Webhooks),
+		"webSubHub", config.WebSubHub,
+		"webSubBuiltinHub", config.WebSubBuiltinHub,
+		"activityPubUser", config.ActivityPubUser,
+		"digestRecipients", len(config.DigestTo),
+		"digestSchedule", config.DigestSchedule,
 		"hideDescription", config.HideDescription)
 
-	if err := http.ListenAndServe(":"+config.Port, nil); err != nil {
-		slog.Error("Failed to start HTTP server", "error", err, "port", config.Port)
-		os.Exit(1)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("failed to start HTTP server on port %s: %w", config.Port, err)
+	case <-ctx.Done():
 	}
+
+	slog.Info("Shutting down feed server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shut down HTTP server: %w", err))
+	}
+	if err := vaults.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to shut down feed generator: %w", err))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	slog.Info("Feed server stopped")
+	return nil
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler runs regenerations on a single goroutine. Triggers are coalesced: a build starts
+// once no trigger arrived for delay, but never later than maxWait after the first trigger it
+// serves, so a constant stream of events cannot postpone it forever. Triggers arriving during
+// a build are folded into the next one.
+type Scheduler struct {
+	delay   time.Duration
+	maxWait time.Duration
+	build   func(ctx context.Context) error
+
+	triggers chan struct{}
+	pending  atomic.Bool
+	done     chan struct{}
+}
+
+// NewScheduler creates a scheduler calling build. A maxWait of zero disables the upper bound.
+func NewScheduler(delay, maxWait time.Duration, build func(ctx context.Context) error) *Scheduler {
+	return &Scheduler{
+		delay:    delay,
+		maxWait:  maxWait,
+		build:    build,
+		triggers: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Trigger requests a build. It never blocks.
+func (s *Scheduler) Trigger() {
+	select {
+	case s.triggers <- struct{}{}:
+	default:
+	}
+}
+
+// Pending reports whether a requested build has not completed yet, including one that was
+// cancelled by the context passed to Run
+func (s *Scheduler) Pending() bool {
+	return s.pending.Load()
+}
+
+// Done is closed when Run has returned
+func (s *Scheduler) Done() <-chan struct{} {
+	return s.done
+}
+
+// Run processes triggers until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	defer close(s.done)
+
+	timer := time.NewTimer(s.delay)
+	timer.Stop()
+	defer timer.Stop()
+
+	var deadline time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-s.triggers:
+			now := time.Now()
+			if deadline.IsZero() {
+				deadline = now.Add(s.maxWait)
+			}
+			s.pending.Store(true)
+
+			wait := s.delay
+			if s.maxWait > 0 {
+				wait = min(wait, max(deadline.Sub(now), 0))
+			}
+			timer.Reset(wait)
+
+		case <-timer.C:
+			deadline = time.Time{}
+			s.runBuild(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) runBuild(ctx context.Context) {
+	slog.Info("Regenerating feeds due to file changes")
+
+	err := s.build(ctx)
+	switch {
+	case errors.Is(err, context.Canceled):
+		slog.Info("Feed regeneration cancelled")
+		return
+	case err != nil:
+		slog.Error("Error during feed regeneration", "error", err)
+	default:
+		slog.Info("Feed regeneration completed")
+	}
+
+	// Triggers received while building are still queued and start a new cycle
+	s.pending.Store(false)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// buildRecorder counts builds and the highest number of builds running at once
+type buildRecorder struct {
+	mu      sync.Mutex
+	builds  int
+	running int
+	maxRun  int
+	delay   time.Duration
+	started chan struct{}
+}
+
+func newBuildRecorder(delay time.Duration) *buildRecorder {
+	return &buildRecorder{delay: delay, started: make(chan struct{}, 100)}
+}
+
+func (r *buildRecorder) build(ctx context.Context) error {
+	r.mu.Lock()
+	r.running++
+	r.maxRun = max(r.maxRun, r.running)
+	r.mu.Unlock()
+	r.started <- struct{}{}
+
+	var err error
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r.mu.Lock()
+	r.running--
+	if err == nil {
+		r.builds++
+	}
+	r.mu.Unlock()
+	return err
+}
+
+func (r *buildRecorder) counts() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.builds, r.maxRun
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSchedulerCoalescesTriggers(t *testing.T) {
+	recorder := newBuildRecorder(0)
+	scheduler := NewScheduler(50*time.Millisecond, 0, recorder.build)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	for range 10 {
+		scheduler.Trigger()
+	}
+	waitFor(t, func() bool { return !scheduler.Pending() && len(recorder.started) == 1 })
+
+	// Give a second, unwanted build the chance to start
+	time.Sleep(100 * time.Millisecond)
+	if builds, _ := recorder.counts(); builds != 1 {
+		t.Errorf("Expected 1 build, got %d", builds)
+	}
+}
+
+func TestSchedulerMaxWait(t *testing.T) {
+	recorder := newBuildRecorder(0)
+	scheduler := NewScheduler(time.Hour, 50*time.Millisecond, recorder.build)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	// A constant stream of triggers would postpone the build forever without maxWait
+	stop := time.After(300 * time.Millisecond)
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			scheduler.Trigger()
+		case <-stop:
+			break loop
+		}
+	}
+
+	if builds, _ := recorder.counts(); builds < 2 {
+		t.Errorf("Expected at least 2 builds within the trigger stream, got %d", builds)
+	}
+}
+
+func TestSchedulerSerializesBuilds(t *testing.T) {
+	recorder := newBuildRecorder(50 * time.Millisecond)
+	scheduler := NewScheduler(time.Millisecond, 0, recorder.build)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go scheduler.Run(ctx)
+
+	scheduler.Trigger()
+	<-recorder.started
+	// Triggers during a build are folded into a single follow-up build
+	for range 10 {
+		scheduler.Trigger()
+	}
+	waitFor(t, func() bool {
+		builds, _ := recorder.counts()
+		return builds == 2 && !scheduler.Pending()
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	builds, maxRun := recorder.counts()
+	if builds != 2 {
+		t.Errorf("Expected 2 builds, got %d", builds)
+	}
+	if maxRun != 1 {
+		t.Errorf("Expected builds to run one at a time, got %d concurrent", maxRun)
+	}
+}
+
+func TestSchedulerCancelKeepsPending(t *testing.T) {
+	recorder := newBuildRecorder(time.Hour)
+	scheduler := NewScheduler(time.Millisecond, 0, recorder.build)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	go scheduler.Run(ctx)
+
+	scheduler.Trigger()
+	<-recorder.started
+	cancel()
+
+	select {
+	case <-scheduler.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the scheduler to stop")
+	}
+
+	if !scheduler.Pending() {
+		t.Error("Expected the cancelled build to remain pending")
+	}
+}
+
+func TestSchedulerTriggerDoesNotBlock(t *testing.T) {
+	var calls atomic.Int32
+	scheduler := NewScheduler(time.Millisecond, 0, func(context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+
+	// Run is not started, so nothing drains the triggers
+	for range 10 {
+		scheduler.Trigger()
+	}
+	if calls.Load() != 0 {
+		t.Error("Expected no build without Run")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+// feedSearch searches the notes of the vaults a generation was built from and returns them
+// as items the way the feeds list them: only notes with a title and a source, once per source
+type feedSearch struct {
+	indexes         []*clippingsfeed.SearchIndex
+	hideDescription bool
+}
+
+type apiSearchItem struct {
+	apiItem
+	Score float64 `json:"score"`
+}
+
+type apiSearchResults struct {
+	Query string          `json:"query"`
+	Items []apiSearchItem `json:"items"`
+	Total int             `json:"total"`
+}
+
+// hits returns the notes matching query, best matches first
+func (s *feedSearch) hits(query string) []clippingsfeed.SearchHit {
+	if s == nil {
+		return nil
+	}
+
+	var hits []clippingsfeed.SearchHit
+	for _, index := range s.indexes {
+		hits = append(hits, index.Search(query)...)
+	}
+	slices.SortStableFunc(hits, func(a, b clippingsfeed.SearchHit) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	var matched []clippingsfeed.SearchHit
+	seen := make(map[string]bool)
+	for _, hit := range hits {
+		meta := hit.Metadata
+		if meta.Source == "" || meta.Title == "" || seen[meta.Source] {
+			continue
+		}
+		seen[meta.Source] = true
+		matched = append(matched, hit)
+	}
+	return matched
+}
+
+// search returns the items matching query, best matches first
+func (s *feedSearch) search(query string) []apiSearchItem {
+	hits := s.hits(query)
+	items := make([]apiSearchItem, 0, len(hits))
+	for _, hit := range hits {
+		items = append(items, apiSearchItem{apiItem: newAPIItem(hit.Metadata, s.hideDescription), Score: hit.Score})
+	}
+	return items
+}
+
+// feed renders the notes matching query, newest first, as a feed in format
+func (s *feedSearch) feed(query string, config clippingsfeed.FeedConfig, format string) ([]byte, error) {
+	var metadata []clippingsfeed.Metadata
+	for _, hit := range s.hits(query) {
+		metadata = append(metadata, hit.Metadata)
+	}
+
+	if config.Title != "" {
+		config.Title += ": " + query
+	} else {
+		config.Title = "Search: " + query
+	}
+	if config.Link != "" {
+		config.Link = strings.TrimRight(config.Link, "/") + "/search?" + url.Values{"q": {query}}.Encode()
+	}
+
+	feed, err := clippingsfeed.GenerateFeed(metadata, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate feed: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := clippingsfeed.WriteFeed(&buf, feed, format); err != nil {
+		return nil, fmt.Errorf("failed to render %s feed: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+type searchPageData struct {
+	Query           string
+	Searched        bool
+	Items           []apiSearchItem
+	Total           int
+	HideDescription bool
+}
+
+const searchHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>{{if .Query}}{{.Query}} - {{end}}Search - Obsidian Clippings Feed</title>
+    {{if .Query}}<link rel="alternate" type="application/rss+xml" title="{{.Query}}" href="search.rss?q={{.Query}}">{{end}}
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        .search { margin-bottom: 30px; }
+        .search input[type=search] { width: 60%; padding: 5px; }
+        .feeds { margin-bottom: 30px; }
+        .feeds a { margin-right: 15px; padding: 5px 10px; background: #007cba; color: white; text-decoration: none; border-radius: 3px; }
+        .stats { margin-bottom: 30px; color: #666; }
+        .items { list-style: none; padding: 0; }
+        .item { margin-bottom: 20px; padding: 15px; border: 1px solid #ddd; border-radius: 5px; }
+        .item-title { font-weight: bold; margin-bottom: 5px; }
+        .item-meta { color: #666; font-size: 0.9em; margin-bottom: 10px; }
+        .item-desc { margin-bottom: 10px; }
+        .item-tags { font-size: 0.8em; color: #999; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>Search</h1>
+        <p><a href="./">Back to recent items</a></p>
+    </div>
+
+    <form class="search" action="search" method="get">
+        <input type="search" name="q" value="{{.Query}}" autofocus>
+        <button type="submit">Search</button>
+    </form>
+    {{if .Searched}}
+    <div class="feeds">
+        <strong>Subscribe to this search:</strong><br><br>
+        <a href="search.rss?q={{.Query}}">RSS</a>
+        <a href="search.atom?q={{.Query}}">Atom</a>
+        <a href="search.json?q={{.Query}}">JSON</a>
+    </div>
+
+    <div class="stats">
+        {{.Total}} items match {{.Query}}{{if gt .Total (len .Items)}}, showing the first {{len .Items}}{{end}}
+    </div>
+
+    <ul class="items">
+    {{range .Items}}
+        <li class="item">
+            <div class="item-title"><a href="{{.Source}}" target="_blank">{{.Title}}</a></div>
+            <div class="item-meta">Site: {{.Site}} | Clipped: {{.Created.Format "2006-01-02"}}</div>
+            {{if not $.HideDescription}}<div class="item-desc">{{.Description}}</div>{{end}}
+            <div class="item-tags">Tags: {{range $i, $tag := .Tags}}{{if $i}}, {{end}}{{$tag}}{{end}}</div>
+        </li>
+    {{end}}
+    </ul>
+    {{end}}
+</body>
+</html>`
+
+var searchTemplate = template.Must(template.New("search").Parse(searchHTMLTemplate))
+
+// registerSearch adds the HTML search page for the generations of store to mux, along with
+// search.rss, search.atom and search.json, which subscribe to the results of a query
+func registerSearch(mux *http.ServeMux, store *Store) {
+	for _, format := range []string{"rss", "atom", "json"} {
+		name := "search." + format
+		mux.HandleFunc("GET /"+name, func(w http.ResponseWriter, r *http.Request) {
+			gen := store.Current()
+			if gen == nil {
+				http.Error(w, "feeds are not generated yet", http.StatusServiceUnavailable)
+				return
+			}
+			query := strings.TrimSpace(r.URL.Query().Get("q"))
+			if query == "" {
+				http.Error(w, "missing q", http.StatusBadRequest)
+				return
+			}
+
+			body, err := gen.search.feed(query, gen.feedConfig, format)
+			if err != nil {
+				slog.Error("Failed to render search feed", "query", query, "format", format, "error", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			serveOutput(w, r, newPlainOutput(name, body), gen.Created)
+		})
+	}
+
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {
+		gen := store.Current()
+		if gen == nil {
+			http.Error(w, "feeds are not generated yet", http.StatusServiceUnavailable)
+			return
+		}
+
+		data := searchPageData{
+			Query:           strings.TrimSpace(r.URL.Query().Get("q")),
+			HideDescription: gen.search == nil || gen.search.hideDescription,
+		}
+		if data.Query != "" {
+			data.Searched = true
+			data.Items = gen.search.search(data.Query)
+			data.Total = len(data.Items)
+			data.Items = data.Items[:min(apiDefaultLimit, len(data.Items))]
+		}
+
+		var buf bytes.Buffer
+		if err := searchTemplate.Execute(&buf, data); err != nil {
+			slog.Error("Failed to render search page", "error", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeFor("search.html"))
+		_, _ = w.Write(buf.Bytes())
+	})
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+func writeSearchNote(t *testing.T, dir, name string, meta clippingsfeed.Metadata, body string) {
+	t.Helper()
+	content := createMarkdownContent(meta) + "\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func searchTitles(t *testing.T, handler http.Handler, query string) []string {
+	t.Helper()
+
+	var results struct {
+		Query string           `json:"query"`
+		Items []map[string]any `json:"items"`
+		Total int              `json:"total"`
+	}
+	getJSON(t, handler, "/api/search?"+url.Values{"q": {query}}.Encode(), http.StatusOK, &results)
+	if results.Query != query {
+		t.Errorf("Expected query %q, got %q", query, results.Query)
+	}
+	if results.Total != len(results.Items) {
+		t.Errorf("Expected total %d, got %d", len(results.Items), results.Total)
+	}
+
+	var titles []string
+	for _, item := range results.Items {
+		if _, ok := item["score"].(float64); !ok {
+			t.Errorf("Item %v has no score", item)
+		}
+		titles = append(titles, item["title"].(string))
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	writeSearchNote(t, dir, "kubernetes.md", clippingsfeed.Metadata{
+		Title: "Kubernetes Operators", Source: "https://example.com/operators", Site: "Cloud Weekly", Created: created,
+	}, "Reconcile loops watch custom resources.")
+	writeSearchNote(t, dir, "japanese.md", clippingsfeed.Metadata{
+		Title: "形態素解析の基礎", Source: "https://example.jp/morph", Tags: []string{"自然言語処理"}, Created: created.Add(time.Hour),
+	}, "日本語の文章を単語に分割する方法を解説します。")
+	writeSearchNote(t, dir, "unsourced.md", clippingsfeed.Metadata{Title: "Draft about operators"}, "")
+
+	vaults, err := NewVaultSet(Config{TargetDir: dir, MaxItems: 50})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	tests := map[string][]string{
+		"operators":         {"Kubernetes Operators"},
+		"reconcile loops":   {"Kubernetes Operators"},
+		"cloud weekly":      {"Kubernetes Operators"},
+		"日本語":               {"形態素解析の基礎"},
+		"単語に分割":             {"形態素解析の基礎"},
+		"言語処理":              {"形態素解析の基礎"},
+		"operators 日本語":     nil,
+		"nothing like this": nil,
+	}
+	for query, expected := range tests {
+		if got := searchTitles(t, handler, query); strings.Join(got, "|") != strings.Join(expected, "|") {
+			t.Errorf("Search %q: expected %v, got %v", query, expected, got)
+		}
+	}
+
+	// The index follows changes to the vault
+	writeSearchNote(t, dir, "kubernetes.md", clippingsfeed.Metadata{
+		Title: "Kubernetes Operators", Source: "https://example.com/operators", Created: created,
+	}, "Now about admission webhooks.")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "kubernetes.md"), later, later); err != nil {
+		t.Fatalf("Failed to touch note: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "japanese.md")); err != nil {
+		t.Fatalf("Failed to remove note: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	if got := searchTitles(t, handler, "reconcile"); got != nil {
+		t.Errorf("Expected the old body to be gone from the index, got %v", got)
+	}
+	if got := searchTitles(t, handler, "webhooks"); len(got) != 1 {
+		t.Errorf("Expected the new body to be indexed, got %v", got)
+	}
+	if got := searchTitles(t, handler, "日本語"); got != nil {
+		t.Errorf("Expected the removed note to be gone from the index, got %v", got)
+	}
+
+	var apiErr map[string]string
+	getJSON(t, handler, "/api/search", http.StatusBadRequest, &apiErr)
+	getJSON(t, handler, "/api/search?q=x&limit=0", http.StatusBadRequest, &apiErr)
+}
+
+func TestSearchGeneration(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	meta := clippingsfeed.Metadata{Title: "Kubernetes Operators", Source: "https://example.com/operators", Created: created}
+	writeSearchNote(t, dir, "kubernetes.md", meta, "Reconcile loops watch custom resources.")
+
+	store := NewStore()
+	generator := NewFeedGenerator(Config{TargetDir: dir, MaxItems: 50}, store)
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := newStoreHandler(store)
+
+	writeSearchNote(t, dir, "kubernetes.md", meta, "Now about admission webhooks.")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "kubernetes.md"), later, later); err != nil {
+		t.Fatalf("Failed to touch note: %v", err)
+	}
+
+	// A build that fails, or is not published yet, leaves the index of the served generation alone
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := generator.Build(ctx); err == nil {
+		t.Fatal("Expected the canceled build to fail")
+	}
+	gen, err := generator.Build(t.Context())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if got := searchTitles(t, handler, "reconcile"); len(got) != 1 {
+		t.Errorf("Expected the served generation to search the notes it was built from, got %v", got)
+	}
+	if got := searchTitles(t, handler, "webhooks"); got != nil {
+		t.Errorf("Expected the unpublished build to stay out of the served index, got %v", got)
+	}
+
+	if err := generator.publish(gen); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	if got := searchTitles(t, handler, "reconcile"); got != nil {
+		t.Errorf("Expected the old body to be gone from the index, got %v", got)
+	}
+	if got := searchTitles(t, handler, "webhooks"); len(got) != 1 {
+		t.Errorf("Expected the new body to be indexed, got %v", got)
+	}
+}
+
+func TestSearchCombined(t *testing.T) {
+	homeDir := t.TempDir()
+	teamDir := t.TempDir()
+	shared := clippingsfeed.Metadata{Title: "Shared Article", Source: "https://example.com/shared", Created: time.Now()}
+	writeSearchNote(t, homeDir, "shared.md", shared, "clipped twice")
+	writeSearchNote(t, teamDir, "shared.md", shared, "clipped twice")
+	writeSearchNote(t, teamDir, "team.md", clippingsfeed.Metadata{Title: "Team Only", Source: "https://example.com/team", Created: time.Now()}, "clipped once")
+
+	vaults, err := NewVaultSet(Config{
+		MaxItems:     50,
+		Roots:        []string{"home=" + homeDir, "team=" + teamDir},
+		CombinedFeed: true,
+	})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	if got := searchTitles(t, handler, "clipped"); len(got) != 2 {
+		t.Errorf("Expected the combined search to list each source once, got %v", got)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/home/api/search?q=clipped", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "Shared Article") || strings.Contains(rec.Body.String(), "Team Only") {
+		t.Errorf("Expected the home vault search to only list its notes, got %s", rec.Body.String())
+	}
+}
+
+func TestSearchPage(t *testing.T) {
+	dir := t.TempDir()
+	writeSearchNote(t, dir, "note.md", clippingsfeed.Metadata{
+		Title: "Escaping <b>tags</b>", Source: "https://example.com/escaping", Description: "Secret description", Created: time.Now(),
+	}, "escaping body")
+
+	vaults, err := NewVaultSet(Config{TargetDir: dir, MaxItems: 50, HideDescription: true})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=escaping", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before the first generation, got %d", rec.Code)
+	}
+
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatalf("Unexpected response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, "1 items match escaping") || !strings.Contains(body, "Escaping &lt;b&gt;tags&lt;/b&gt;") {
+		t.Errorf("Expected the escaped result, got %s", body)
+	}
+	if strings.Contains(body, "Secret description") {
+		t.Error("Expected the description to be hidden")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "items match") {
+		t.Errorf("Expected an empty search form, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestSearchFeeds(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	writeSearchNote(t, dir, "older.md", clippingsfeed.Metadata{Title: "Older Kubernetes Post", Source: "https://example.com/older", Created: created}, "")
+	writeSearchNote(t, dir, "newer.md", clippingsfeed.Metadata{Title: "Newer Kubernetes Post", Source: "https://example.com/newer", Created: created.Add(time.Hour)}, "")
+	writeSearchNote(t, dir, "other.md", clippingsfeed.Metadata{Title: "Unrelated", Source: "https://example.com/other", Created: created}, "")
+
+	vaults, err := NewVaultSet(Config{TargetDir: dir, MaxItems: 50, FeedTitle: "Clippings", FeedLink: "https://feeds.example.com/"})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	tests := map[string]string{
+		"search.rss":  "application/rss+xml; charset=utf-8",
+		"search.atom": "application/atom+xml; charset=utf-8",
+		"search.json": "application/feed+json; charset=utf-8",
+	}
+	for name, contentType := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/"+name+"?q=kubernetes", nil)
+			req.Header.Set("Accept-Encoding", "br, gzip")
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != contentType {
+				t.Errorf("Expected Content-Type %q, got %q", contentType, got)
+			}
+			// Results are rendered per request and not worth compressing at the best level
+			if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Expected an uncompressed search feed, got %q", got)
+			}
+
+			body := rec.Body.String()
+			newer := strings.Index(body, "Newer Kubernetes Post")
+			older := strings.Index(body, "Older Kubernetes Post")
+			if newer < 0 || older < 0 || newer > older {
+				t.Errorf("Expected both matches, newest first, got %s", body)
+			}
+			if strings.Contains(body, "Unrelated") {
+				t.Error("Expected only matching clippings")
+			}
+			if !strings.Contains(body, "Clippings: kubernetes") || !strings.Contains(body, "https://feeds.example.com/search?q=kubernetes") {
+				t.Errorf("Expected the feed to be titled and linked after the search, got %s", body)
+			}
+
+			// Readers polling the feed get 304 until the next generation
+			req = httptest.NewRequest(http.MethodGet, "/"+name+"?q=kubernetes", nil)
+			req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("Expected 304, got %d", rec.Code)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search.rss", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a query, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search?q=kubernetes+post", nil))
+	if !strings.Contains(rec.Body.String(), `href="search.rss?q=kubernetes%20post"`) {
+		t.Errorf("Expected the search page to link its feeds, got %s", rec.Body.String())
+	}
+}
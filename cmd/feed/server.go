@@ -1,65 +1,213 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io/fs"
 	"log/slog"
 	"os"
-	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
 	"github.com/yuin/goldmark"
 )
 
 type FeedGenerator struct {
-	config        Config
-	tmpDir        string
-	parser        goldmark.Markdown
-	watcher       *fsnotify.Watcher
-	debounceTimer *time.Timer
+	config     Config
+	store      *Store
+	parser     goldmark.Markdown
+	source     clippingsfeed.Source
+	walkOpts   clippingsfeed.WalkOptions
+	updateMode string
+
+	scheduler *Scheduler
+
+	// onPublish is called after every published generation, e.g. to rebuild a combined feed
+	onPublish func() error
+
+	// notify is called with every published generation, e.g. to deliver webhooks
+	notify []func(gen *Generation)
+
+	// watchDone and reconcileDone are closed when the respective loop has exited
+	watchDone     chan struct{}
+	reconcileDone chan struct{}
+
+	// indexed is the state of the notes the last generation was built from
+	indexMu sync.Mutex
+	indexed clippingsfeed.Snapshot
+
+	// search is the full-text index of the last generation, which the next build copies and
+	// updates with the notes that changed
+	search *clippingsfeed.SearchIndex
+}
+
+// NewFeedGenerator creates a generator for the vault directory config.TargetDir
+func NewFeedGenerator(config Config, store *Store) *FeedGenerator {
+	return NewFeedGeneratorWithSource(config, store, nil)
 }
 
-func NewFeedGenerator(config Config, tmpDir string) *FeedGenerator {
+// NewFeedGeneratorWithSource creates a generator for the vault read from source, or for the
+// directory config.TargetDir when source is nil, watched with fsnotify unless polling is
+// configured
+func NewFeedGeneratorWithSource(config Config, store *Store, source clippingsfeed.Source) *FeedGenerator {
+	walkOpts := clippingsfeed.WalkOptions{
+		Ignore:         ignoreMatcher(config),
+		FollowSymlinks: config.FollowSymlinks,
+	}
+
+	switch {
+	case source != nil:
+	case config.WatcherBackend == "poll":
+		source = clippingsfeed.NewPollingSource(os.DirFS(config.TargetDir), config.PollInterval, walkOpts)
+	default:
+		source = clippingsfeed.NewNotifySource(config.TargetDir, config.PollInterval, walkOpts)
+	}
+
 	return &FeedGenerator{
-		config: config,
-		tmpDir: tmpDir,
-		parser: clippingsfeed.CreateParser(),
+		config:     config,
+		store:      store,
+		parser:     clippingsfeed.CreateParser(),
+		source:     source,
+		walkOpts:   walkOpts,
+		updateMode: "file watcher",
+		search:     clippingsfeed.NewSearchIndex(),
+	}
+}
+
+// ignoreMatcher returns the rules for the paths of the vault that are skipped: the defaults
+// and FEED_IGNORE
+func ignoreMatcher(config Config) *clippingsfeed.IgnoreMatcher {
+	return clippingsfeed.NewIgnoreMatcher(append(slices.Clone(clippingsfeed.DefaultIgnorePatterns), config.Ignore...))
+}
+
+// Build scans the vault and renders every output without publishing them
+func (g *FeedGenerator) Build(ctx context.Context) (*Generation, error) {
+	if syncer, ok := g.source.(clippingsfeed.SyncSource); ok {
+		if err := syncer.Sync(ctx); err != nil {
+			return nil, fmt.Errorf("failed to sync vault: %w", err)
+		}
+	}
+
+	// The notes are indexed into a copy, so the published generation keeps searching the index
+	// it was built with while this build runs, and after it fails
+	search := g.search.Clone()
+	fsys := g.source.FS()
+	metadata, snapshot, err := clippingsfeed.ScanVaultFunc(ctx, g.parser, fsys, g.walkOpts, search.Index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan markdown files: %w", err)
 	}
+	search.Prune(snapshot)
+
+	gen, err := g.buildGeneration(metadata)
+	if err != nil {
+		return nil, err
+	}
+	gen.search = &feedSearch{indexes: []*clippingsfeed.SearchIndex{search}, hideDescription: g.config.HideDescription}
+
+	g.indexMu.Lock()
+	g.indexed = snapshot
+	g.search = search
+	g.indexMu.Unlock()
+
+	return gen, nil
 }
 
-func (g *FeedGenerator) GenerateFeeds() error {
-	metadata, err := g.scanMarkdownFiles()
+// GenerateFeeds scans the vault and publishes every output as one generation
+func (g *FeedGenerator) GenerateFeeds(ctx context.Context) error {
+	gen, err := g.Build(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to scan markdown files: %w", err)
+		return err
 	}
 
+	return g.publish(gen)
+}
+
+// publish makes gen the served generation, exports it when configured and notifies onPublish
+func (g *FeedGenerator) publish(gen *Generation) error {
+	g.store.Publish(gen)
+	slog.Info("Generated feeds", "directory", g.config.TargetDir, "itemCount", len(gen.Metadata))
+
+	if g.config.ExportDir != "" {
+		if err := gen.Export(g.config.ExportDir); err != nil {
+			return fmt.Errorf("failed to export feeds: %w", err)
+		}
+		slog.Debug("Exported feeds", "dir", g.config.ExportDir)
+	}
+
+	for _, notify := range g.notify {
+		notify(gen)
+	}
+	if g.onPublish != nil {
+		return g.onPublish()
+	}
+	return nil
+}
+
+func (g *FeedGenerator) buildGeneration(metadata []clippingsfeed.Metadata) (*Generation, error) {
+	now := time.Now()
+	gen := NewGeneration(now, metadata)
+	gen.api = newAPIIndex(metadata, g.config.HideDescription)
+
 	feedConfig := clippingsfeed.FeedConfig{
 		Title:           g.config.FeedTitle,
 		Link:            g.config.FeedLink,
 		Description:     g.config.FeedDesc,
 		Author:          g.config.FeedAuthor,
-		Created:         time.Now(),
+		Created:         now,
 		MaxItems:        g.config.MaxItems,
 		HideDescription: g.config.HideDescription,
 	}
 
+	gen.feedConfig = feedConfig
+
 	feed, err := clippingsfeed.GenerateFeed(metadata, feedConfig)
 	if err != nil {
-		return fmt.Errorf("failed to generate feed: %w", err)
+		return nil, fmt.Errorf("failed to generate feed: %w", err)
 	}
 
-	for _, filename := range []string{"feed.rss", "feed.atom", "feed.json"} {
-		if err := clippingsfeed.WriteFeedToFile(feed, filepath.Join(g.tmpDir, filename)); err != nil {
-			slog.Error("failed to write feed"+filename, "error", err)
+	digest, err := json.Marshal(feed.Items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode feed items: %w", err)
+	}
+	sum := sha256.Sum256(digest)
+	gen.feedDigest = hex.EncodeToString(sum[:])
+
+	for _, format := range []string{"rss", "atom", "json"} {
+		name := "feed." + format
+		var links clippingsfeed.FeedLinks
+		if g.config.WebSubHub != "" {
+			links = clippingsfeed.FeedLinks{Self: feedURL(g.config.FeedLink, name), Hub: g.config.WebSubHub}
+		}
+
+		var buf bytes.Buffer
+		if err := clippingsfeed.WriteFeedWithLinks(&buf, feed, format, links); err != nil {
+			return nil, fmt.Errorf("failed to render %s feed: %w", format, err)
+		}
+		if err := gen.Add(name, buf.Bytes()); err != nil {
+			return nil, err
+		}
+		if links.Hub != "" {
+			gen.Outputs[name].Link = feedLinkHeader(links.Hub, links.Self)
 		}
 	}
 
-	slog.Info("Generated feeds", "itemCount", len(metadata))
-	return nil
+	indexHTML, err := g.renderIndexHTML(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate index.html: %w", err)
+	}
+	if err := gen.Add("index.html", indexHTML); err != nil {
+		return nil, err
+	}
+
+	return gen, nil
 }
 
 // Template data structure for HTML rendering
@@ -111,9 +259,9 @@ const indexHTMLTemplate = `<!DOCTYPE html>
     
     <div class="feeds">
         <strong>Available feeds:</strong><br><br>
-        <a href="/feed.rss">RSS</a>
-        <a href="/feed.atom">Atom</a>
-        <a href="/feed.json">JSON</a>
+        <a href="feed.rss">RSS</a>
+        <a href="feed.atom">Atom</a>
+        <a href="feed.json">JSON</a>
     </div>
     
     <div class="stats">
@@ -140,16 +288,7 @@ const indexHTMLTemplate = `<!DOCTYPE html>
 </body>
 </html>`
 
-func (g *FeedGenerator) GenerateIndexHTML(filename string) error {
-	metadata, err := g.scanMarkdownFiles()
-	if err != nil {
-		return fmt.Errorf("failed to scan markdown files: %w", err)
-	}
-
-	return g.generateIndexHTMLFromMetadata(filename, metadata)
-}
-
-func (g *FeedGenerator) generateIndexHTMLFromMetadata(filename string, metadata []clippingsfeed.Metadata) error {
+func (g *FeedGenerator) renderIndexHTML(metadata []clippingsfeed.Metadata) ([]byte, error) {
 	// Process metadata: filter, sort, and limit (same as feed generation)
 	filteredMetadata := clippingsfeed.FilterValidMetadata(metadata)
 	clippingsfeed.SortMetadataByCreated(filteredMetadata)
@@ -158,7 +297,7 @@ func (g *FeedGenerator) generateIndexHTMLFromMetadata(filename string, metadata
 	// Create template
 	tmpl, err := template.New("index").Parse(indexHTMLTemplate)
 	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
+		return nil, fmt.Errorf("failed to parse template: %w", err)
 	}
 
 	// Prepare template data
@@ -192,165 +331,184 @@ func (g *FeedGenerator) generateIndexHTMLFromMetadata(filename string, metadata
 		TargetDir:       g.config.TargetDir,
 		Items:           items,
 		LastUpdated:     time.Now().Format("2006-01-02 15:04:05"),
-		UpdateMode:      "file watcher",
+		UpdateMode:      g.updateMode,
 		HideDescription: g.config.HideDescription,
 	}
 
-	// Create output file
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close() //nolint:errcheck
-
 	// Execute template
-	if err := tmpl.Execute(file, data); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
 	}
 
-	return nil
+	return buf.Bytes(), nil
 }
 
-func (g *FeedGenerator) StartFileWatcher() error {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return fmt.Errorf("failed to create watcher: %w", err)
+// StartFileWatcher watches the vault and regenerates the feeds on changes until ctx is done
+func (g *FeedGenerator) StartFileWatcher(ctx context.Context) error {
+	switch g.config.WatcherBackend {
+	case "", "fsnotify":
+	case "poll":
+		if g.config.PollInterval <= 0 {
+			return fmt.Errorf("poll interval must be positive, got %s", g.config.PollInterval)
+		}
+	default:
+		return fmt.Errorf("unsupported watcher backend: %s (supported: fsnotify, poll)", g.config.WatcherBackend)
 	}
-	g.watcher = watcher
 
-	if err := g.addWatchesRecursively(g.config.TargetDir); err != nil {
-		return fmt.Errorf("failed to add watches: %w", err)
-	}
+	g.watchDone = make(chan struct{})
+	g.scheduler = NewScheduler(g.config.DebounceDelay, g.config.DebounceMaxWait, g.GenerateFeeds)
+	go g.watchLoop(ctx)
+	go g.scheduler.Run(ctx)
+
+	g.reconcileDone = make(chan struct{})
+	go g.reconcileLoop(ctx)
 
-	go g.watchLoop()
 	slog.Info("File watcher started", "directory", g.config.TargetDir)
 	return nil
 }
 
-func (g *FeedGenerator) addWatchesRecursively(dir string) error {
-	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if d.IsDir() {
-			if err := g.watcher.Add(path); err != nil {
-				slog.Warn("Failed to watch directory", "directory", path, "error", err)
-				return nil
-			}
-			slog.Debug("Watching directory", "directory", path)
+// hasIndexedNotesBelow reports whether the last generation included notes below dir, a
+// slash-separated path relative to the vault root
+func (g *FeedGenerator) hasIndexedNotesBelow(dir string) bool {
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+	if dir == "." {
+		return len(g.indexed) > 0
+	}
+	for path := range g.indexed {
+		if strings.HasPrefix(path, dir+"/") {
+			return true
 		}
-		return nil
-	})
+	}
+	return false
 }
 
-func (g *FeedGenerator) watchLoop() {
-	defer func() {
-		if err := g.watcher.Close(); err != nil {
-			slog.Error("Error closing file watcher", "error", err)
-		}
-	}()
+// reconcileLoop periodically compares the notes the last generation was built from with the
+// vault on disk and schedules a rebuild when they differ, catching changes the watcher missed
+func (g *FeedGenerator) reconcileLoop(ctx context.Context) {
+	defer close(g.reconcileDone)
 
-	for {
-		select {
-		case event, ok := <-g.watcher.Events:
-			if !ok {
-				return
-			}
+	if g.config.ReconcileInterval <= 0 {
+		return
+	}
 
-			if g.shouldProcessEvent(event) {
-				g.debouncedRegenerate()
-			}
+	ticker := time.NewTicker(g.config.ReconcileInterval)
+	defer ticker.Stop()
 
-			if event.Op&fsnotify.Create == fsnotify.Create {
-				if stat, err := os.Stat(event.Name); err == nil && stat.IsDir() {
-					if err := g.watcher.Add(event.Name); err != nil {
-						slog.Warn("Failed to watch new directory", "directory", event.Name, "error", err)
-					} else {
-						slog.Info("Added watch for new directory", "directory", event.Name)
-					}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := g.reconcile(ctx)
+			if err != nil {
+				if !errors.Is(err, context.Canceled) {
+					slog.Error("Failed to reconcile vault", "error", err)
 				}
+				continue
 			}
-
-		case err, ok := <-g.watcher.Errors:
-			if !ok {
-				return
+			if changed {
+				slog.Info("Reconciliation found changes missed by the file watcher")
+				g.scheduler.Trigger()
 			}
-			slog.Error("File watcher error", "error", err)
 		}
 	}
 }
 
-func (g *FeedGenerator) shouldProcessEvent(event fsnotify.Event) bool {
-	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
-		return false
+// reconcile reports whether the vault on disk differs from the last indexed state
+func (g *FeedGenerator) reconcile(ctx context.Context) (bool, error) {
+	snapshot, err := clippingsfeed.TakeSnapshot(ctx, g.source.FS(), ".", g.walkOpts)
+	if err != nil {
+		return false, err
 	}
 
-	if strings.HasSuffix(strings.ToLower(event.Name), ".md") {
-		slog.Info("Detected change in markdown file", "file", event.Name, "operation", event.Op.String())
-		return true
-	}
+	g.indexMu.Lock()
+	defer g.indexMu.Unlock()
+	return !snapshot.Equal(g.indexed), nil
+}
 
-	return false
+// sinceWatcher is a source that can report the changes made since a known state of the vault,
+// such as clippingsfeed.NotifySource and clippingsfeed.PollingSource
+type sinceWatcher interface {
+	WatchSince(ctx context.Context, previous clippingsfeed.Snapshot, notify func(clippingsfeed.Change)) error
 }
 
-func (g *FeedGenerator) debouncedRegenerate() {
-	if g.debounceTimer != nil {
-		g.debounceTimer.Stop()
+// watchLoop schedules regenerations for the changes reported by the source
+func (g *FeedGenerator) watchLoop(ctx context.Context) {
+	defer close(g.watchDone)
+
+	notify := g.handleChange
+	var err error
+	if watcher, ok := g.source.(sinceWatcher); ok {
+		// Start from the notes the feeds were built from, not from the vault as it is now
+		g.indexMu.Lock()
+		since := g.indexed
+		g.indexMu.Unlock()
+		err = watcher.WatchSince(ctx, since, notify)
+	} else {
+		err = g.source.Watch(ctx, notify)
+	}
+	if err != nil {
+		slog.Error("Failed to watch vault", "error", err)
 	}
 
-	g.debounceTimer = time.AfterFunc(g.config.DebounceDelay, func() {
-		slog.Info("Regenerating feeds due to file changes")
-
-		if err := g.GenerateFeeds(); err != nil {
-			slog.Error("Error during feed regeneration", "error", err)
-		}
-
-		indexHTML := filepath.Join(g.tmpDir, "index.html")
-		if err := g.GenerateIndexHTML(indexHTML); err != nil {
-			slog.Error("Error during index regeneration", "error", err, "file", indexHTML)
-		}
+	slog.Info("File watcher stopped")
+}
 
-		slog.Info("Feed regeneration completed")
-	})
+// handleChange schedules a regeneration for changes that affect the notes of the vault
+func (g *FeedGenerator) handleChange(change clippingsfeed.Change) {
+	if g.shouldProcessChange(change) {
+		g.scheduler.Trigger()
+	}
 }
 
-func (g *FeedGenerator) scanMarkdownFiles() ([]clippingsfeed.Metadata, error) {
-	var metadata []clippingsfeed.Metadata
+func (g *FeedGenerator) shouldProcessChange(change clippingsfeed.Change) bool {
+	switch {
+	case change.Op == clippingsfeed.ChangeRescan:
+		slog.Info("Rescanning vault after missed changes", "directory", change.Path)
+		return true
 
-	err := filepath.WalkDir(g.config.TargetDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	case clippingsfeed.IsMarkdownFile(change.Path):
+		if g.walkOpts.Ignored(change.Path, false) {
+			return false
 		}
+		slog.Info("Detected change in markdown file", "file", change.Path, "operation", change.Op.String())
+		return true
 
-		if d.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".md") {
-			return nil
-		}
+	case change.Op == clippingsfeed.ChangeRemove || change.Op == clippingsfeed.ChangeRename:
+		// A directory removed or moved out takes its notes along
+		return g.hasIndexedNotesBelow(change.Path)
+	}
 
-		content, err := os.ReadFile(path)
-		if err != nil {
-			slog.Warn("Error reading file", "file", path, "error", err)
-			return nil
-		}
+	return false
+}
 
-		meta, err := clippingsfeed.ParseMeta(g.parser, string(content))
-		if err != nil {
-			slog.Warn("Error parsing metadata", "file", path, "error", err)
-			return nil
-		}
+// Shutdown waits for the watch loop and the scheduler to stop, which happens once the
+// context passed to StartFileWatcher is done, and settles any pending regeneration. A pending
+// or cancelled one is flushed when feeds are exported to disk, so the export reflects the
+// vault at exit; otherwise it is dropped with the in-memory store.
+func (g *FeedGenerator) Shutdown(ctx context.Context) error {
+	if g.watchDone == nil {
+		return nil
+	}
 
-		if meta.Title == "" {
-			meta.Title = filepath.Base(path)
-		}
-		if meta.Created.IsZero() {
-			if stat, err := os.Stat(path); err == nil {
-				meta.Created = stat.ModTime()
-			}
+	for _, done := range []<-chan struct{}{g.watchDone, g.reconcileDone, g.scheduler.Done()} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+	}
 
-		metadata = append(metadata, *meta)
+	if !g.scheduler.Pending() {
 		return nil
-	})
+	}
+	if g.config.ExportDir == "" {
+		slog.Info("Dropped pending feed regeneration")
+		return nil
+	}
 
-	return metadata, err
+	slog.Info("Flushing pending feed regeneration")
+	return g.GenerateFeeds(ctx)
 }
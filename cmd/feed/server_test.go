@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -89,15 +92,8 @@ func TestGenerateIndexHTML(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create temporary directory for test
-			tmpDir := t.TempDir()
-
 			// Create generator with test config
-			generator := &FeedGenerator{
-				config: tt.config,
-				tmpDir: tmpDir,
-				parser: clippingsfeed.CreateParser(),
-			}
+			generator := NewFeedGenerator(tt.config, NewStore())
 
 			// Load test data
 			metadata, err := loadTestData(t, tt.testData)
@@ -106,16 +102,9 @@ func TestGenerateIndexHTML(t *testing.T) {
 			}
 
 			// Generate HTML using the helper method
-			outputFile := filepath.Join(tmpDir, "index.html")
-			err = generator.generateIndexHTMLFromMetadata(outputFile, metadata)
-			if err != nil {
-				t.Fatalf("GenerateIndexHTML failed: %v", err)
-			}
-
-			// Read generated HTML
-			content, err := os.ReadFile(outputFile)
+			content, err := generator.renderIndexHTML(metadata)
 			if err != nil {
-				t.Fatalf("Failed to read generated HTML: %v", err)
+				t.Fatalf("renderIndexHTML failed: %v", err)
 			}
 
 			// Normalize timestamp for consistent testing
@@ -286,13 +275,6 @@ func TestGenerateFeeds(t *testing.T) {
 			// Create temporary directory for test
 			tmpDir := t.TempDir()
 
-			// Create generator with test config
-			generator := &FeedGenerator{
-				config: tt.config,
-				tmpDir: tmpDir,
-				parser: clippingsfeed.CreateParser(),
-			}
-
 			// Create a temporary markdown file for scanning
 			testMarkdownDir := filepath.Join(tmpDir, "markdown")
 			err := os.MkdirAll(testMarkdownDir, 0755)
@@ -316,11 +298,13 @@ func TestGenerateFeeds(t *testing.T) {
 				}
 			}
 
-			// Update generator's target directory to scan the test files
-			generator.config.TargetDir = testMarkdownDir
+			// Create generator with test config, scanning the test files
+			tt.config.TargetDir = testMarkdownDir
+			store := NewStore()
+			generator := NewFeedGenerator(tt.config, store)
 
 			// Call GenerateFeeds
-			err = generator.GenerateFeeds()
+			err = generator.GenerateFeeds(t.Context())
 			if tt.expectError {
 				if err == nil {
 					t.Errorf("Expected error but got none")
@@ -331,31 +315,584 @@ func TestGenerateFeeds(t *testing.T) {
 				t.Fatalf("GenerateFeeds failed: %v", err)
 			}
 
-			// Verify feed files were created
+			// Verify feed outputs were published
+			gen := store.Current()
+			if gen == nil {
+				t.Fatal("No generation was published")
+			}
 			feedFiles := []string{"feed.rss", "feed.atom", "feed.json"}
 			for _, filename := range feedFiles {
-				feedPath := filepath.Join(tmpDir, filename)
-				if _, err := os.Stat(feedPath); os.IsNotExist(err) {
-					t.Errorf("Feed file %s was not created", filename)
+				output, ok := gen.Outputs[filename]
+				if !ok {
+					t.Errorf("Feed output %s was not created", filename)
+					continue
 				}
 
-				// Verify file has content
-				content, err := os.ReadFile(feedPath)
-				if err != nil {
-					t.Errorf("Failed to read feed file %s: %v", filename, err)
-				}
-				if len(content) == 0 {
-					t.Errorf("Feed file %s is empty", filename)
+				// Verify output has content
+				if len(output.Body) == 0 {
+					t.Errorf("Feed output %s is empty", filename)
 				}
 
 				// Basic content validation
-				contentStr := string(content)
+				contentStr := string(output.Body)
 				if !strings.Contains(contentStr, tt.config.FeedTitle) {
-					t.Errorf("Feed file %s does not contain expected title", filename)
+					t.Errorf("Feed output %s does not contain expected title", filename)
 				}
+
+				// Verify pre-compressed variants
+				for _, encoding := range clippingsfeed.Encodings {
+					if len(output.Encoded[encoding.Name]) == 0 {
+						t.Errorf("Compressed feed output %s was not created", filename+encoding.Extension)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateFeedsPublishesGenerations(t *testing.T) {
+	markdownDir := t.TempDir()
+	exportDir := t.TempDir()
+
+	store := NewStore()
+	generator := NewFeedGenerator(Config{
+		FeedTitle: "Generation Feed",
+		TargetDir: markdownDir,
+		MaxItems:  50,
+		ExportDir: exportDir,
+	}, store)
+
+	if gen := store.Current(); gen != nil {
+		t.Fatal("Expected no generation before GenerateFeeds")
+	}
+
+	var generations []*Generation
+	for i := range 2 {
+		metadata, err := loadTestData(t, "success")
+		if err != nil {
+			t.Fatalf("Failed to load test data: %v", err)
+		}
+		metadata[0].Title = fmt.Sprintf("Generation %d", i)
+		if err := os.WriteFile(filepath.Join(markdownDir, "note.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+			t.Fatalf("Failed to write test markdown file: %v", err)
+		}
+
+		if err := generator.GenerateFeeds(t.Context()); err != nil {
+			t.Fatalf("GenerateFeeds failed: %v", err)
+		}
+		gen := store.Current()
+		generations = append(generations, gen)
+
+		// Every format of a generation is rendered from the same scan
+		for _, filename := range []string{"feed.rss", "feed.atom", "feed.json", "index.html"} {
+			if !strings.Contains(string(gen.Outputs[filename].Body), fmt.Sprintf("Generation %d", i)) {
+				t.Errorf("%s of generation %d does not contain its item", filename, i)
+			}
+
+			// The export directory mirrors the published generation
+			content, err := os.ReadFile(filepath.Join(exportDir, filename))
+			if err != nil {
+				t.Fatalf("Failed to read exported %s: %v", filename, err)
+			}
+			if string(content) != string(gen.Outputs[filename].Body) {
+				t.Errorf("Exported %s does not match generation %d", filename, i)
+			}
+		}
+	}
+
+	if generations[0] == generations[1] {
+		t.Fatal("Expected a new generation per GenerateFeeds call")
+	}
+}
+
+// waitForPendingRegeneration blocks until the watcher has scheduled a regeneration
+func waitForPendingRegeneration(t *testing.T, generator *FeedGenerator) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if generator.scheduler.Pending() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for a pending regeneration")
+}
+
+func TestShutdownPendingRegeneration(t *testing.T) {
+	tests := []struct {
+		name        string
+		export      bool
+		expectFlush bool
+	}{
+		{name: "flushed to export", export: true, expectFlush: true},
+		{name: "dropped without export", export: false, expectFlush: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			markdownDir := t.TempDir()
+			config := Config{
+				FeedTitle:     "Shutdown Feed",
+				TargetDir:     markdownDir,
+				MaxItems:      50,
+				DebounceDelay: time.Hour,
+			}
+			if tt.export {
+				config.ExportDir = t.TempDir()
+			}
+
+			store := NewStore()
+			generator := NewFeedGenerator(config, store)
+			if err := generator.GenerateFeeds(t.Context()); err != nil {
+				t.Fatalf("GenerateFeeds failed: %v", err)
+			}
+			initial := store.Current()
+
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			if err := generator.StartFileWatcher(ctx); err != nil {
+				t.Fatalf("StartFileWatcher failed: %v", err)
+			}
+
+			metadata, err := loadTestData(t, "success")
+			if err != nil {
+				t.Fatalf("Failed to load test data: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(markdownDir, "note.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+				t.Fatalf("Failed to write test markdown file: %v", err)
+			}
+			waitForPendingRegeneration(t, generator)
+
+			cancel()
+			shutdownCtx, shutdownCancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer shutdownCancel()
+			if err := generator.Shutdown(shutdownCtx); err != nil {
+				t.Fatalf("Shutdown failed: %v", err)
+			}
+
+			flushed := store.Current() != initial
+			if flushed != tt.expectFlush {
+				t.Fatalf("Expected flush %v, got %v", tt.expectFlush, flushed)
+			}
+			if tt.export {
+				content, err := os.ReadFile(filepath.Join(config.ExportDir, "feed.rss"))
+				if err != nil {
+					t.Fatalf("Failed to read exported feed: %v", err)
+				}
+				if !strings.Contains(string(content), metadata[0].Title) {
+					t.Error("Exported feed does not contain the pending change")
+				}
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	markdownDir := t.TempDir()
+	generator := NewFeedGenerator(Config{TargetDir: markdownDir, MaxItems: 50}, NewStore())
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	changed, err := generator.reconcile(t.Context())
+	if err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if changed {
+		t.Error("Expected no changes right after a generation")
+	}
+
+	metadata, err := loadTestData(t, "success")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(markdownDir, "missed.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+
+	changed, err = generator.reconcile(t.Context())
+	if err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+	if !changed {
+		t.Error("Expected reconciliation to detect the new note")
+	}
+}
+
+func TestReconcileLoopRegenerates(t *testing.T) {
+	markdownDir := t.TempDir()
+	// A source that misses every change
+	store := NewStore()
+	generator := NewFeedGeneratorWithSource(Config{
+		TargetDir:         markdownDir,
+		MaxItems:          50,
+		DebounceDelay:     time.Millisecond,
+		ReconcileInterval: 20 * time.Millisecond,
+	}, store, clippingsfeed.NewFSSource(os.DirFS(markdownDir)))
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := generator.StartFileWatcher(ctx); err != nil {
+		t.Fatalf("StartFileWatcher failed: %v", err)
+	}
+
+	metadata, err := loadTestData(t, "success")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(markdownDir, "missed.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 1 })
+}
+
+// changeSource is a vault directory that only reports the changes sent to it
+type changeSource struct {
+	dir     string
+	changes chan clippingsfeed.Change
+}
+
+func (s changeSource) FS() fs.FS {
+	return os.DirFS(s.dir)
+}
+
+func (s changeSource) Watch(ctx context.Context, notify func(clippingsfeed.Change)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change := <-s.changes:
+			notify(change)
+		}
+	}
+}
+
+func TestWatcherRescanAndRemovedDirectories(t *testing.T) {
+	markdownDir := t.TempDir()
+	source := changeSource{dir: markdownDir, changes: make(chan clippingsfeed.Change)}
+	store := NewStore()
+	generator := NewFeedGeneratorWithSource(Config{
+		TargetDir:     markdownDir,
+		MaxItems:      50,
+		DebounceDelay: time.Millisecond,
+	}, store, source)
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := generator.StartFileWatcher(ctx); err != nil {
+		t.Fatalf("StartFileWatcher failed: %v", err)
+	}
+
+	metadata, err := loadTestData(t, "success")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(markdownDir, "Clippings"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(markdownDir, "Clippings", "lost.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+
+	// A source that lost events has the vault rescanned
+	source.changes <- clippingsfeed.Change{Path: ".", Op: clippingsfeed.ChangeRescan}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 1 })
+
+	// A removed directory drops the notes below it, one without notes is not worth a rebuild
+	if generator.shouldProcessChange(clippingsfeed.Change{Path: "Attachments", Op: clippingsfeed.ChangeRemove}) {
+		t.Error("Expected a removed directory without notes to be skipped")
+	}
+	if err := os.RemoveAll(filepath.Join(markdownDir, "Clippings")); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	source.changes <- clippingsfeed.Change{Path: "Clippings", Op: clippingsfeed.ChangeRemove}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 0 })
+}
+
+func TestWatcherDirectoryMoves(t *testing.T) {
+	markdownDir := t.TempDir()
+	outsideDir := t.TempDir()
+
+	// A folder of clippings with a nested subfolder, prepared outside the vault
+	movedDir := filepath.Join(outsideDir, "clippings")
+	if err := os.MkdirAll(filepath.Join(movedDir, "nested"), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(movedDir, "nested", "first.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+
+	store := NewStore()
+	generator := NewFeedGenerator(Config{
+		TargetDir:     markdownDir,
+		MaxItems:      50,
+		DebounceDelay: time.Millisecond,
+	}, store)
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := generator.StartFileWatcher(ctx); err != nil {
+		t.Fatalf("StartFileWatcher failed: %v", err)
+	}
+
+	// Moving the folder in indexes the notes already inside it
+	vaultDir := filepath.Join(markdownDir, "clippings")
+	if err := os.Rename(movedDir, vaultDir); err != nil {
+		t.Fatalf("Failed to move directory into the vault: %v", err)
+	}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 1 })
+
+	// Its subdirectories are watched as well
+	if err := os.WriteFile(filepath.Join(vaultDir, "nested", "second.md"), []byte(createMarkdownContent(metadata[1])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 2 })
+
+	// Moving it out drops its notes and its watches
+	if err := os.Rename(vaultDir, filepath.Join(outsideDir, "moved-out")); err != nil {
+		t.Fatalf("Failed to move directory out of the vault: %v", err)
+	}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 0 })
+
+	cancel()
+	if err := generator.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+}
+
+func TestFollowSymlinks(t *testing.T) {
+	markdownDir := t.TempDir()
+	sharedDir := t.TempDir()
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+
+	// A folder shared with another vault and linked into this one, plus a link back to the
+	// vault root that must not make the scan loop
+	if err := os.WriteFile(filepath.Join(sharedDir, "first.md"), []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+	if err := os.Symlink(sharedDir, filepath.Join(markdownDir, "Shared")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(markdownDir, filepath.Join(sharedDir, "vault")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		followSymlinks bool
+		expectedItems  int
+	}{
+		{name: "not followed", expectedItems: 0},
+		{name: "followed", followSymlinks: true, expectedItems: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore()
+			generator := NewFeedGenerator(Config{
+				TargetDir:      markdownDir,
+				MaxItems:       50,
+				FollowSymlinks: tt.followSymlinks,
+			}, store)
+			if err := generator.GenerateFeeds(t.Context()); err != nil {
+				t.Fatalf("GenerateFeeds failed: %v", err)
+			}
+			if got := len(store.Current().Metadata); got != tt.expectedItems {
+				t.Errorf("Expected %d items, got %d", tt.expectedItems, got)
 			}
 		})
 	}
+
+	// Changes inside the link target are picked up through the watch on the target
+	store := NewStore()
+	generator := NewFeedGenerator(Config{
+		TargetDir:      markdownDir,
+		MaxItems:       50,
+		DebounceDelay:  time.Millisecond,
+		FollowSymlinks: true,
+	}, store)
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := generator.StartFileWatcher(ctx); err != nil {
+		t.Fatalf("StartFileWatcher failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sharedDir, "second.md"), []byte(createMarkdownContent(metadata[1])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 2 })
+
+	cancel()
+	if err := generator.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	markdownDir := t.TempDir()
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+
+	notes := map[string]clippingsfeed.Metadata{
+		"Clippings/first.md":   metadata[0],
+		".trash/second.md":     metadata[1],
+		".obsidian/second.md":  metadata[1],
+		"Templates/clipper.md": metadata[1],
+	}
+	for name, meta := range notes {
+		filename := filepath.Join(markdownDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filename, []byte(createMarkdownContent(meta)), 0644); err != nil {
+			t.Fatalf("Failed to write test markdown file: %v", err)
+		}
+	}
+
+	store := NewStore()
+	generator := NewFeedGenerator(Config{
+		TargetDir:     markdownDir,
+		MaxItems:      50,
+		DebounceDelay: time.Millisecond,
+		Ignore:        []string{"Templates/"},
+	}, store)
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	gen := store.Current()
+	if len(gen.Metadata) != 1 || gen.Metadata[0].Title != metadata[0].Title {
+		t.Fatalf("Expected only %s to be scanned, got %v", metadata[0].Title, gen.Metadata)
+	}
+
+	// Changes to ignored notes do not trigger a regeneration
+	change := clippingsfeed.Change{Path: "Templates/clipper.md", Op: clippingsfeed.ChangeWrite}
+	if generator.shouldProcessChange(change) {
+		t.Error("Expected changes in ignored directories to be skipped")
+	}
+	change = clippingsfeed.Change{Path: "Clippings/first.md", Op: clippingsfeed.ChangeWrite}
+	if !generator.shouldProcessChange(change) {
+		t.Error("Expected changes to notes in the vault to be processed")
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	markdownDir := t.TempDir()
+	metadata, err := loadTestData(t, "feed_multiple")
+	if err != nil {
+		t.Fatalf("Failed to load test data: %v", err)
+	}
+	first := filepath.Join(markdownDir, "first.md")
+	if err := os.WriteFile(first, []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+
+	store := NewStore()
+	generator := NewFeedGenerator(Config{
+		TargetDir:      markdownDir,
+		MaxItems:       50,
+		DebounceDelay:  time.Millisecond,
+		WatcherBackend: "poll",
+		PollInterval:   20 * time.Millisecond,
+	}, store)
+	if err := generator.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := generator.StartFileWatcher(ctx); err != nil {
+		t.Fatalf("StartFileWatcher failed: %v", err)
+	}
+	if _, ok := generator.source.(*clippingsfeed.PollingSource); !ok {
+		t.Errorf("Expected the poll backend to poll the vault, got %T", generator.source)
+	}
+
+	hasTitle := func(title string) bool {
+		for _, meta := range store.Current().Metadata {
+			if meta.Title == title {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Create
+	if err := os.WriteFile(filepath.Join(markdownDir, "second.md"), []byte(createMarkdownContent(metadata[1])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+	waitFor(t, func() bool { return hasTitle(metadata[1].Title) })
+
+	// Modify
+	metadata[0].Title = "Modified Article"
+	if err := os.WriteFile(first, []byte(createMarkdownContent(metadata[0])), 0644); err != nil {
+		t.Fatalf("Failed to write test markdown file: %v", err)
+	}
+	waitFor(t, func() bool { return hasTitle("Modified Article") })
+
+	// Delete
+	if err := os.Remove(first); err != nil {
+		t.Fatalf("Failed to remove test markdown file: %v", err)
+	}
+	waitFor(t, func() bool { return len(store.Current().Metadata) == 1 })
+
+	cancel()
+	if err := generator.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+}
+
+func TestStartFileWatcherInvalidBackend(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown backend", config: Config{WatcherBackend: "kqueue"}},
+		{name: "zero poll interval", config: Config{WatcherBackend: "poll"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.TargetDir = t.TempDir()
+			generator := NewFeedGenerator(tt.config, NewStore())
+			if err := generator.StartFileWatcher(t.Context()); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestBuildCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	generator := NewFeedGenerator(Config{TargetDir: t.TempDir()}, NewStore())
+	if _, err := generator.Build(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
 }
 
 // createMarkdownContent creates markdown content with YAML frontmatter from metadata
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+// statePath returns the path of the state file name in config.StateDir. feature, which keeps
+// state across restarts, cannot be enabled without it: the working directory is read-only in
+// the container image.
+func statePath(config Config, feature, name string) (string, error) {
+	if config.StateDir == "" {
+		return "", fmt.Errorf("%s needs FEED_STATE_DIR to keep its state", feature)
+	}
+	return filepath.Join(config.StateDir, name), nil
+}
+
+// loadState decodes the state file filename into v and reports whether it exists
+func loadState(filename string, v any) (bool, error) {
+	data, err := os.ReadFile(filename)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to read state: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse state %s: %w", filename, err)
+	}
+	return true, nil
+}
+
+// saveState replaces the state file filename with v encoded as JSON. State files may hold
+// secrets, so only the owner can read them.
+func saveState(filename string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state %s: %w", filename, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", filename, err)
+	}
+	err = clippingsfeed.WriteFileAtomicMode(filename, 0o600, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// clippingTracker finds the clippings added to the vaults, by source URL. It is part of the
+// state of the webhooks, the ActivityPub actor and the digest.
+type clippingTracker struct {
+	// Vaults lists the vaults whose clippings were recorded as known when their first
+	// generation was seen, so only clippings added afterwards count as added
+	Vaults []string `json:"vaults"`
+	// Known is the set of source URLs seen so far
+	Known map[string]bool `json:"known"`
+}
+
+// add records the items of a generation of vault and returns those with a source URL not seen
+// before. The first generation of a vault only records the items it already has. changed
+// reports whether anything was recorded, so the state needs saving.
+func (t *clippingTracker) add(vault string, items []apiItem) (added []apiItem, changed bool) {
+	if t.Known == nil {
+		t.Known = make(map[string]bool)
+	}
+	first := !slices.Contains(t.Vaults, vault)
+	if first {
+		t.Vaults = append(t.Vaults, vault)
+		changed = true
+	}
+	for _, item := range items {
+		if t.Known[item.Source] {
+			continue
+		}
+		t.Known[item.Source] = true
+		changed = true
+		if !first {
+			added = append(added, item)
+		}
+	}
+	return added, changed
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestClippingTracker(t *testing.T) {
+	var tracker clippingTracker
+	first := apiItem{Source: "https://example.com/first"}
+	second := apiItem{Source: "https://example.com/second"}
+
+	// The first generation of a vault only records what it already has
+	if added, changed := tracker.add("home", []apiItem{first}); len(added) != 0 || !changed {
+		t.Errorf("Expected the first generation to be recorded, got %v, %v", added, changed)
+	}
+	if added, changed := tracker.add("home", []apiItem{first}); len(added) != 0 || changed {
+		t.Errorf("Expected nothing to change, got %v, %v", added, changed)
+	}
+	if added, changed := tracker.add("home", []apiItem{second, first}); len(added) != 1 || added[0].Source != second.Source || !changed {
+		t.Errorf("Expected the second clipping to be added, got %v, %v", added, changed)
+	}
+
+	// Another vault starts with what it has, even clippings known from other vaults
+	third := apiItem{Source: "https://example.com/third"}
+	if added, changed := tracker.add("work", []apiItem{first, third}); len(added) != 0 || !changed {
+		t.Errorf("Expected the first generation of another vault to be recorded, got %v, %v", added, changed)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state", "tracker.json")
+
+	var missing clippingTracker
+	if found, err := loadState(filename, &missing); found || err != nil {
+		t.Errorf("Expected no state yet, got %v, %v", found, err)
+	}
+
+	saved := clippingTracker{Vaults: []string{"home"}, Known: map[string]bool{"https://example.com/first": true}}
+	if err := saveState(filename, saved); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the state to be private, got %v, %v", info, err)
+	}
+
+	var loaded clippingTracker
+	if found, err := loadState(filename, &loaded); !found || err != nil {
+		t.Fatalf("Expected the state to be loaded, got %v, %v", found, err)
+	}
+	if !slices.Equal(loaded.Vaults, saved.Vaults) || !loaded.Known["https://example.com/first"] {
+		t.Errorf("Expected %+v, got %+v", saved, loaded)
+	}
+
+	if err := os.WriteFile(filename, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	if _, err := loadState(filename, &loaded); err == nil {
+		t.Error("Expected an error for an invalid state")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+// Output is one rendered file of a generation together with its pre-compressed variants
+type Output struct {
+	Name        string
+	ContentType string
+	Body        []byte
+	// Encoded holds the compressed bodies keyed by encoding name
+	Encoded map[string][]byte
+	ETag    string
+	// Link is the Link header advertising the WebSub hub of a feed, if any
+	Link string
+}
+
+// Generation is the complete set of outputs rendered from one scan of the vault
+type Generation struct {
+	Created  time.Time
+	Metadata []clippingsfeed.Metadata
+	Outputs  map[string]*Output
+
+	// api indexes the metadata for the JSON API
+	api *apiIndex
+
+	// search looks up the notes of the vaults the generation was built from
+	search *feedSearch
+
+	// feedConfig is what the feeds were generated with, for feeds generated on request
+	feedConfig clippingsfeed.FeedConfig
+
+	// feedDigest identifies the items of the feeds, which unlike the feeds themselves do not
+	// change with every generation
+	feedDigest string
+}
+
+func NewGeneration(created time.Time, metadata []clippingsfeed.Metadata) *Generation {
+	return &Generation{
+		Created:  created,
+		Metadata: metadata,
+		Outputs:  make(map[string]*Output),
+	}
+}
+
+// Add renders the compressed variants of body and stores it under name, e.g. "feed.rss"
+func (gen *Generation) Add(name string, body []byte) error {
+	output, err := newOutput(name, body)
+	if err != nil {
+		return err
+	}
+	gen.Outputs[name] = output
+	return nil
+}
+
+// newOutput renders the compressed variants of body
+func newOutput(name string, body []byte) (*Output, error) {
+	output := newPlainOutput(name, body)
+	output.Encoded = make(map[string][]byte, len(clippingsfeed.Encodings))
+	for _, encoding := range clippingsfeed.Encodings {
+		compressed, err := clippingsfeed.Compress(body, encoding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", name, err)
+		}
+		output.Encoded[encoding.Name] = compressed
+	}
+
+	return output, nil
+}
+
+// newPlainOutput creates an output of body that is served uncompressed, for a response rendered
+// per request, which compressing at the best level would make costly
+func newPlainOutput(name string, body []byte) *Output {
+	sum := sha256.Sum256(body)
+	return &Output{
+		Name:        name,
+		ContentType: contentTypeFor(name),
+		Body:        body,
+		ETag:        hex.EncodeToString(sum[:8]),
+	}
+}
+
+// Names returns the names of all outputs in a stable order
+func (gen *Generation) Names() []string {
+	names := make([]string, 0, len(gen.Outputs))
+	for name := range gen.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Export writes every output and its compressed variants to dir. Each file is replaced
+// atomically, so a web server serving dir never sees a half-written file.
+func (gen *Generation) Export(dir string) error {
+	for _, name := range gen.Names() {
+		output := gen.Outputs[name]
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", name, err)
+		}
+
+		if err := writeBytesAtomic(filename, output.Body); err != nil {
+			return err
+		}
+		for _, encoding := range clippingsfeed.Encodings {
+			if err := writeBytesAtomic(filename+encoding.Extension, output.Encoded[encoding.Name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeBytesAtomic(filename string, data []byte) error {
+	err := clippingsfeed.WriteFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Store holds the generation currently being served. Publishing swaps the whole generation at
+// once, so every request observes the outputs of a single scan.
+type Store struct {
+	current atomic.Pointer[Generation]
+
+	// events records how the items changed between generations
+	events *eventLog
+}
+
+func NewStore() *Store {
+	return &Store{events: newEventLog(eventHistoryLimit)}
+}
+
+func (s *Store) Publish(gen *Generation) {
+	previous := s.current.Swap(gen)
+	s.events.record(previous, gen)
+}
+
+// Current returns the published generation, or nil before the first one
+func (s *Store) Current() *Generation {
+	return s.current.Load()
+}
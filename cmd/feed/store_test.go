@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+func TestGenerationExport(t *testing.T) {
+	gen := NewGeneration(time.Now(), nil)
+	for name, content := range map[string]string{
+		"feed.rss":    "<rss></rss>",
+		"tags/go.rss": "<rss>go</rss>",
+		"index.html":  "<html></html>",
+	} {
+		if err := gen.Add(name, []byte(content)); err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := gen.Export(dir); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	for _, name := range gen.Names() {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to read exported %s: %v", name, err)
+		}
+		if string(content) != string(gen.Outputs[name].Body) {
+			t.Errorf("Exported %s has content %q", name, content)
+		}
+
+		for _, encoding := range clippingsfeed.Encodings {
+			compressed, err := os.ReadFile(filename + encoding.Extension)
+			if err != nil {
+				t.Fatalf("Failed to read exported %s: %v", name+encoding.Extension, err)
+			}
+			if string(compressed) != string(gen.Outputs[name].Encoded[encoding.Name]) {
+				t.Errorf("Exported %s does not match the generation", name+encoding.Extension)
+			}
+		}
+	}
+}
+
+func TestStorePublish(t *testing.T) {
+	store := NewStore()
+	if store.Current() != nil {
+		t.Fatal("Expected no generation in a new store")
+	}
+
+	first := NewGeneration(time.Now(), nil)
+	store.Publish(first)
+	second := NewGeneration(time.Now(), nil)
+	store.Publish(second)
+
+	if store.Current() != second {
+		t.Error("Expected the last published generation to be current")
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+var vaultNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// reservedVaultNames are the paths served next to the vaults: the JSON API, search page and
+// event stream of the combined feed, the WebSub hub and the ActivityPub actor
+var reservedVaultNames = []string{"api", "search", "events", "hub", "ap"}
+
+type vaultRoot struct {
+	name string
+	dir  string
+}
+
+// parseVaultRoots parses FEED_ROOTS entries of the form name=path. Names become the URL
+// path and export subdirectory of the vault, so they are limited to letters, digits, "-"
+// and "_", and cannot be one of the paths the server serves besides the vaults.
+func parseVaultRoots(specs []string) ([]vaultRoot, error) {
+	var roots []vaultRoot
+	seen := make(map[string]struct{})
+
+	for _, spec := range specs {
+		name, dir, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("invalid vault root %q: expected name=path", spec)
+		}
+		if !vaultNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid vault name %q: use letters, digits, '-' and '_'", name)
+		}
+		if slices.Contains(reservedVaultNames, name) {
+			return nil, fmt.Errorf("invalid vault name %q: reserved names are %s", name, strings.Join(reservedVaultNames, ", "))
+		}
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate vault name %q", name)
+		}
+		seen[name] = struct{}{}
+
+		roots = append(roots, vaultRoot{name: name, dir: dir})
+	}
+
+	return roots, nil
+}
+
+// Vault is one vault root with its own feeds. A vault without a name is served at "/".
+type Vault struct {
+	Name      string
+	Generator *FeedGenerator
+	Store     *Store
+}
+
+// VaultSet runs a feed generator per vault root. With several roots, each vault is served
+// below /<name>/ and exported to <ExportDir>/<name>, and the optional combined feed of all
+// vaults is served at "/" and exported to ExportDir.
+type VaultSet struct {
+	vaults []*Vault
+
+	// combined renders the combined feed; it never scans or watches a directory itself
+	combined   *FeedGenerator
+	combinedMu sync.Mutex
+
+	// webhooks posts new clippings of every vault, webhooksDone is closed once it stopped
+	webhooks     *Webhooks
+	webhooksDone chan struct{}
+
+	// hub is the built-in WebSub hub, if enabled, pinger pings an external one
+	hub    *WebSubHub
+	pinger *webSubPinger
+
+	// activityPub publishes new clippings of every vault to its followers, if enabled
+	activityPub *ActivityPub
+
+	// digest mails new clippings of every vault, digestDone is closed once it stopped
+	digest     *Digest
+	digestDone chan struct{}
+}
+
+// NewVaultSet creates the vaults listed in config.Roots, or a single vault for
+// config.TargetDir when no roots are configured
+func NewVaultSet(config Config) (*VaultSet, error) {
+	return newVaultSet(config, true)
+}
+
+// newVaultSet creates the vaults like NewVaultSet. Without notify, nothing is told about new
+// clippings, as a static build is no running service that could follow up on them.
+func newVaultSet(config Config, notify bool) (*VaultSet, error) {
+	roots, err := parseVaultRoots(config.Roots)
+	if err != nil {
+		return nil, err
+	}
+	if config.WebSubBuiltinHub && notify {
+		config.WebSubHub = feedURL(config.FeedLink, "hub")
+	}
+
+	if len(roots) == 0 {
+		store := NewStore()
+		generator, err := newVaultGenerator(config, store)
+		if err != nil {
+			return nil, err
+		}
+		set := &VaultSet{vaults: []*Vault{{Generator: generator, Store: store}}}
+		if notify {
+			if err := set.setupNotifications(config); err != nil {
+				return nil, err
+			}
+		}
+		return set, nil
+	}
+
+	set := &VaultSet{}
+	dirs := make([]string, 0, len(roots))
+	for _, root := range roots {
+		rootConfig := config
+		rootConfig.TargetDir = root.dir
+		rootConfig.FeedTitle = config.FeedTitle + " - " + root.name
+		rootConfig.FeedLink = strings.TrimRight(config.FeedLink, "/") + "/" + root.name + "/"
+		if config.ExportDir != "" {
+			rootConfig.ExportDir = filepath.Join(config.ExportDir, root.name)
+		}
+
+		store := NewStore()
+		generator, err := newVaultGenerator(rootConfig, store)
+		if err != nil {
+			return nil, fmt.Errorf("vault %s: %w", root.name, err)
+		}
+		set.vaults = append(set.vaults, &Vault{Name: root.name, Generator: generator, Store: store})
+		dirs = append(dirs, generator.config.TargetDir)
+	}
+
+	if config.CombinedFeed {
+		combinedConfig := config
+		combinedConfig.TargetDir = strings.Join(dirs, ", ")
+		set.combined = NewFeedGenerator(combinedConfig, NewStore())
+
+		for _, vault := range set.vaults {
+			vault.Generator.onPublish = set.publishCombined
+		}
+	}
+
+	if notify {
+		if err := set.setupNotifications(config); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// setupNotifications tells the webhooks, the email digest, the ActivityPub actor and the
+// WebSub hub configured in config about the generations of every vault
+func (s *VaultSet) setupNotifications(config Config) error {
+	webhooks, err := NewWebhooks(config)
+	if err != nil {
+		return err
+	}
+	if webhooks != nil {
+		s.webhooks = webhooks
+		for _, vault := range s.vaults {
+			vault.Generator.notify = append(vault.Generator.notify, func(gen *Generation) {
+				webhooks.Notify(vault.Name, gen)
+			})
+		}
+	}
+
+	digest, err := NewDigest(config)
+	if err != nil {
+		return err
+	}
+	if digest != nil {
+		s.digest = digest
+		for _, vault := range s.vaults {
+			vault.Generator.notify = append(vault.Generator.notify, func(gen *Generation) {
+				digest.Notify(vault.Name, gen)
+			})
+		}
+	}
+
+	activityPub, err := NewActivityPub(config)
+	if err != nil {
+		return err
+	}
+	if activityPub != nil {
+		s.activityPub = activityPub
+		for _, vault := range s.vaults {
+			vault.Generator.notify = append(vault.Generator.notify, func(gen *Generation) {
+				activityPub.Notify(vault.Name, gen)
+			})
+		}
+	}
+
+	if config.WebSubHub == "" {
+		return nil
+	}
+	var publisher webSubPublisher
+	if config.WebSubBuiltinHub {
+		stateFile, err := statePath(config, "the built-in WebSub hub", "websub.json")
+		if err != nil {
+			return err
+		}
+		if s.hub, err = NewWebSubHub(config.WebSubHub, stateFile); err != nil {
+			return err
+		}
+		publisher = s.hub
+	} else {
+		s.pinger = newWebSubPinger(config.WebSubHub)
+		publisher = s.pinger
+	}
+	generators := make([]*FeedGenerator, 0, len(s.vaults)+1)
+	for _, vault := range s.vaults {
+		generators = append(generators, vault.Generator)
+	}
+	if s.combined != nil {
+		generators = append(generators, s.combined)
+	}
+	for _, generator := range generators {
+		generator.notify = append(generator.notify, newWebSubNotifier(generator.config, publisher))
+	}
+	return nil
+}
+
+// Vaults returns the vaults in configuration order
+func (s *VaultSet) Vaults() []*Vault {
+	return s.vaults
+}
+
+// setUpdateMode sets the update mode shown on every index page
+func (s *VaultSet) setUpdateMode(mode string) {
+	for _, vault := range s.vaults {
+		vault.Generator.updateMode = mode
+	}
+	if s.combined != nil {
+		s.combined.updateMode = mode
+	}
+}
+
+// GenerateFeeds generates the feeds of every vault, and with them the combined feed
+func (s *VaultSet) GenerateFeeds(ctx context.Context) error {
+	for _, vault := range s.vaults {
+		if err := vault.Generator.GenerateFeeds(ctx); err != nil {
+			return vaultError(vault, err)
+		}
+	}
+	return nil
+}
+
+// StartFileWatchers starts watching every vault until ctx is done
+func (s *VaultSet) StartFileWatchers(ctx context.Context) error {
+	for _, vault := range s.vaults {
+		if err := vault.Generator.StartFileWatcher(ctx); err != nil {
+			return vaultError(vault, err)
+		}
+	}
+	return nil
+}
+
+// StartWebhooks delivers new clippings to the configured webhooks until ctx is done
+func (s *VaultSet) StartWebhooks(ctx context.Context) {
+	if s.webhooks == nil {
+		return
+	}
+
+	s.webhooksDone = make(chan struct{})
+	go func() {
+		defer close(s.webhooksDone)
+		s.webhooks.Run(ctx)
+	}()
+}
+
+// StartDigest mails the configured digest on its schedule until ctx is done
+func (s *VaultSet) StartDigest(ctx context.Context) {
+	if s.digest == nil {
+		return
+	}
+
+	s.digestDone = make(chan struct{})
+	go func() {
+		defer close(s.digestDone)
+		s.digest.Run(ctx)
+	}()
+}
+
+// Shutdown shuts the generator of every vault down, see FeedGenerator.Shutdown, and waits for
+// the webhooks and the digest to stop and the WebSub and ActivityPub requests to finish.
+// Webhook deliveries still pending are resumed on the next start.
+func (s *VaultSet) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, vault := range s.vaults {
+		if err := vault.Generator.Shutdown(ctx); err != nil {
+			errs = append(errs, vaultError(vault, err))
+		}
+	}
+	if s.webhooksDone != nil {
+		select {
+		case <-s.webhooksDone:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("failed to stop webhooks: %w", ctx.Err()))
+		}
+	}
+	if s.digestDone != nil {
+		select {
+		case <-s.digestDone:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("failed to stop digest: %w", ctx.Err()))
+		}
+	}
+	if s.hub != nil {
+		if err := s.hub.wait(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to finish WebSub requests: %w", err))
+		}
+	}
+	if s.pinger != nil {
+		if err := s.pinger.wait(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to finish WebSub pings: %w", err))
+		}
+	}
+	if s.activityPub != nil {
+		if err := s.activityPub.wait(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to finish ActivityPub deliveries: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Handler serves the outputs and the JSON API of every vault and of the combined feed, the
+// built-in WebSub hub at /hub and the ActivityPub actor below /ap/
+func (s *VaultSet) Handler() http.Handler {
+	mux := http.NewServeMux()
+	if s.hub != nil {
+		mux.Handle("/hub", s.hub)
+	}
+	if s.activityPub != nil {
+		s.activityPub.register(mux)
+	}
+	for _, vault := range s.vaults {
+		if vault.Name == "" {
+			mux.Handle("/", newStoreHandler(vault.Store))
+			continue
+		}
+		mux.Handle("/"+vault.Name+"/", http.StripPrefix("/"+vault.Name, newStoreHandler(vault.Store)))
+	}
+	if s.combined != nil {
+		mux.Handle("/", newStoreHandler(s.combined.store))
+	}
+	return mux
+}
+
+// publishCombined rebuilds the combined feed from the current generation of every vault,
+// keeping one item per source URL. It waits until every vault has been generated once.
+func (s *VaultSet) publishCombined() error {
+	s.combinedMu.Lock()
+	defer s.combinedMu.Unlock()
+
+	var metadata []clippingsfeed.Metadata
+	search := &feedSearch{hideDescription: s.combined.config.HideDescription}
+	for _, vault := range s.vaults {
+		gen := vault.Store.Current()
+		if gen == nil {
+			return nil
+		}
+		metadata = append(metadata, gen.Metadata...)
+		search.indexes = append(search.indexes, gen.search.indexes...)
+	}
+
+	gen, err := s.combined.buildGeneration(clippingsfeed.DeduplicateMetadataBySource(metadata))
+	if err != nil {
+		return fmt.Errorf("failed to build combined feed: %w", err)
+	}
+	gen.search = search
+	return s.combined.publish(gen)
+}
+
+func vaultError(vault *Vault, err error) error {
+	if vault.Name == "" {
+		return err
+	}
+	return fmt.Errorf("vault %s: %w", vault.Name, err)
+}
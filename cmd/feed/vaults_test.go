@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+func TestParseVaultRoots(t *testing.T) {
+	tests := []struct {
+		name        string
+		specs       []string
+		expected    []vaultRoot
+		expectError bool
+	}{
+		{
+			name:     "none",
+			expected: nil,
+		},
+		{
+			name:     "several roots",
+			specs:    []string{"home=/vaults/home", " team=/vaults/team "},
+			expected: []vaultRoot{{name: "home", dir: "/vaults/home"}, {name: "team", dir: "/vaults/team"}},
+		},
+		{
+			name:     "path with equals sign",
+			specs:    []string{"home=/vaults/a=b"},
+			expected: []vaultRoot{{name: "home", dir: "/vaults/a=b"}},
+		},
+		{name: "missing name", specs: []string{"/vaults/home"}, expectError: true},
+		{name: "missing path", specs: []string{"home="}, expectError: true},
+		{name: "invalid name", specs: []string{"my vault=/vaults/home"}, expectError: true},
+		{name: "name with dot", specs: []string{"feed.rss=/vaults/home"}, expectError: true},
+		{name: "duplicate name", specs: []string{"home=/a", "home=/b"}, expectError: true},
+		{name: "reserved name", specs: []string{"home=/a", "api=/b"}, expectError: true},
+		{name: "reserved ActivityPub name", specs: []string{"ap=/a"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roots, err := parseVaultRoots(tt.specs)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(roots) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, roots)
+			}
+			for i := range roots {
+				if roots[i] != tt.expected[i] {
+					t.Errorf("Expected %v, got %v", tt.expected[i], roots[i])
+				}
+			}
+		})
+	}
+}
+
+// writeVaultNotes writes one note per metadata item into dir
+func writeVaultNotes(t *testing.T, dir string, metadata []clippingsfeed.Metadata) {
+	t.Helper()
+
+	for _, meta := range metadata {
+		filename := filepath.Join(dir, meta.Title+".md")
+		if err := os.WriteFile(filename, []byte(createMarkdownContent(meta)), 0644); err != nil {
+			t.Fatalf("Failed to write test markdown file: %v", err)
+		}
+	}
+}
+
+func TestVaultSet(t *testing.T) {
+	homeDir := t.TempDir()
+	teamDir := t.TempDir()
+	baseTime := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	shared := clippingsfeed.Metadata{Title: "Shared Article", Source: "https://example.com/shared", Created: baseTime}
+	writeVaultNotes(t, homeDir, []clippingsfeed.Metadata{
+		shared,
+		{Title: "Home Article", Source: "https://example.com/home", Created: baseTime},
+	})
+	teamShared := shared
+	teamShared.Title = "Shared Article Again"
+	teamShared.Created = baseTime.Add(time.Hour)
+	writeVaultNotes(t, teamDir, []clippingsfeed.Metadata{
+		teamShared,
+		{Title: "Team Article", Source: "https://example.com/team", Created: baseTime},
+	})
+
+	vaults, err := NewVaultSet(Config{
+		FeedTitle:     "Clippings",
+		FeedLink:      "https://feeds.example.com/",
+		MaxItems:      50,
+		DebounceDelay: time.Millisecond,
+		Roots:         []string{"home=" + homeDir, "team=" + teamDir},
+		CombinedFeed:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	handler := vaults.Handler()
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	tests := []struct {
+		path        string
+		contains    []string
+		notContains []string
+	}{
+		{
+			path:        "/home/feed.rss",
+			contains:    []string{"Clippings - home", "https://feeds.example.com/home/", "Shared Article", "Home Article"},
+			notContains: []string{"Team Article"},
+		},
+		{
+			path:        "/team/feed.rss",
+			contains:    []string{"Clippings - team", "Shared Article Again", "Team Article"},
+			notContains: []string{"Home Article"},
+		},
+		{
+			path:     "/team/",
+			contains: []string{`href="feed.rss"`, "Team Article"},
+		},
+		{
+			// The earlier clipping of an article in both vaults is kept
+			path:        "/feed.rss",
+			contains:    []string{"Home Article", "Team Article", "Shared Article"},
+			notContains: []string{"Shared Article Again"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			code, body := get(tt.path)
+			if code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", code)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("Expected %s to contain %q", tt.path, s)
+				}
+			}
+			for _, s := range tt.notContains {
+				if strings.Contains(body, s) {
+					t.Errorf("Expected %s not to contain %q", tt.path, s)
+				}
+			}
+		})
+	}
+	if len(vaults.combined.store.Current().Metadata) != 3 {
+		t.Errorf("Expected 3 combined items, got %d", len(vaults.combined.store.Current().Metadata))
+	}
+
+	// A change in one vault regenerates its feeds and the combined feed
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := vaults.StartFileWatchers(ctx); err != nil {
+		t.Fatalf("StartFileWatchers failed: %v", err)
+	}
+	writeVaultNotes(t, teamDir, []clippingsfeed.Metadata{
+		{Title: "New Team Article", Source: "https://example.com/new", Created: baseTime},
+	})
+	waitFor(t, func() bool { return len(vaults.combined.store.Current().Metadata) == 4 })
+
+	cancel()
+	if err := vaults.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+}
+
+func TestVaultSetWithoutCombinedFeed(t *testing.T) {
+	homeDir := t.TempDir()
+	exportDir := t.TempDir()
+
+	vaults, err := NewVaultSet(Config{
+		MaxItems:  50,
+		Roots:     []string{"home=" + homeDir},
+		ExportDir: exportDir,
+	})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	vaults.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.rss", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a combined feed, got %d", rec.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(exportDir, "home", "feed.rss")); err != nil {
+		t.Errorf("Expected the vault to be exported to its own directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(exportDir, "feed.rss")); !os.IsNotExist(err) {
+		t.Errorf("Expected no combined feed to be exported, got %v", err)
+	}
+}
+
+func TestNewVaultSetInvalidRoots(t *testing.T) {
+	if _, err := NewVaultSet(Config{Roots: []string{"home"}}); err == nil {
+		t.Error("Expected error but got none")
+	}
+}
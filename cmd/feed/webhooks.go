@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	webhookEventAdded    = "clipping.added"
+	webhookTimeout       = 30 * time.Second
+	webhookMaxRetryDelay = 5 * time.Minute
+)
+
+// States of the delivery of a clipping to a webhook target
+const (
+	webhookPending   = "pending"
+	webhookDelivered = "delivered"
+	webhookFailed    = "failed"
+)
+
+// webhookPayload is the JSON body posted for a new clipping, and the data of a body template
+type webhookPayload struct {
+	Event string  `json:"event"`
+	Vault string  `json:"vault,omitempty"`
+	Item  apiItem `json:"item"`
+}
+
+type webhookDelivery struct {
+	Status   string    `json:"status"`
+	Found    time.Time `json:"found"`
+	Updated  time.Time `json:"updated"`
+	Attempts int       `json:"attempts,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// Payload is kept until the delivery is done, so pending deliveries survive a restart
+	Payload *webhookPayload `json:"payload,omitempty"`
+
+	// next is when a failed attempt is retried
+	next time.Time
+}
+
+type webhookTargetState struct {
+	// Vaults is only read to take over the state of earlier versions, which tracked the
+	// clippings per target
+	Vaults []string `json:"vaults,omitempty"`
+	// Deliveries is keyed by source URL
+	Deliveries map[string]*webhookDelivery `json:"deliveries"`
+}
+
+type webhookState struct {
+	clippingTracker
+	// Targets is keyed by webhookKey, as the URLs of the targets often hold their secret
+	Targets map[string]*webhookTargetState `json:"targets"`
+}
+
+// Webhooks posts every clipping with a new source URL to the configured targets. What was
+// delivered is saved to a state file, so a restart neither sends a clipping again nor loses
+// a delivery that is still being retried.
+type Webhooks struct {
+	targets     []string
+	template    *template.Template
+	contentType string
+	stateFile   string
+	maxAttempts int
+	retryDelay  time.Duration
+	client      *http.Client
+
+	mu    sync.Mutex
+	state webhookState
+
+	// queued is signalled when deliveries are added
+	queued chan struct{}
+}
+
+// NewWebhooks creates the webhooks of config.Webhooks, or returns nil when none are configured
+func NewWebhooks(config Config) (*Webhooks, error) {
+	if len(config.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	stateFile, err := statePath(config, "webhooks", "webhooks.json")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Webhooks{
+		contentType: config.WebhookContentType,
+		stateFile:   stateFile,
+		maxAttempts: max(config.WebhookMaxAttempts, 1),
+		retryDelay:  config.WebhookRetryDelay,
+		client:      &http.Client{Timeout: webhookTimeout},
+		state:       webhookState{Targets: make(map[string]*webhookTargetState)},
+		queued:      make(chan struct{}, 1),
+	}
+
+	for _, target := range config.Webhooks {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q: expected http or https", webhookName(target))
+		}
+		w.targets = append(w.targets, target)
+	}
+
+	if config.WebhookTemplate != "" {
+		text, err := os.ReadFile(config.WebhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook template: %w", err)
+		}
+		w.template, err = template.New(filepath.Base(config.WebhookTemplate)).Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				data, err := json.Marshal(v)
+				return string(data), err
+			},
+		}).Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse webhook template: %w", err)
+		}
+	}
+
+	if _, err := loadState(w.stateFile, &w.state); err != nil {
+		return nil, fmt.Errorf("failed to load webhook state: %w", err)
+	}
+	// Earlier versions keyed the state by the URL itself and tracked the clippings per target
+	for key, state := range w.state.Targets {
+		if strings.Contains(key, "://") {
+			delete(w.state.Targets, key)
+			w.state.Targets[webhookKey(key)] = state
+		}
+		for _, vault := range state.Vaults {
+			if !slices.Contains(w.state.Vaults, vault) {
+				w.state.Vaults = append(w.state.Vaults, vault)
+			}
+		}
+		state.Vaults = nil
+		for source := range state.Deliveries {
+			if w.state.Known == nil {
+				w.state.Known = make(map[string]bool)
+			}
+			w.state.Known[source] = true
+		}
+	}
+
+	return w, nil
+}
+
+// webhookKey identifies target in the state file without revealing its URL
+func webhookKey(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return hex.EncodeToString(sum[:])
+}
+
+// webhookName identifies target in logs and errors without the path and credentials, which
+// often hold the secret of a chat webhook
+func webhookName(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (s *webhookState) target(target string) *webhookTargetState {
+	if s.Targets == nil {
+		s.Targets = make(map[string]*webhookTargetState)
+	}
+	key := webhookKey(target)
+	state, ok := s.Targets[key]
+	if !ok {
+		state = &webhookTargetState{}
+		s.Targets[key] = state
+	}
+	if state.Deliveries == nil {
+		state.Deliveries = make(map[string]*webhookDelivery)
+	}
+	return state
+}
+
+// Notify queues the clippings added to vault in gen for every target
+func (w *Webhooks) Notify(vault string, gen *Generation) {
+	if gen.api == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	added, changed := w.state.add(vault, gen.api.items)
+	if !changed {
+		return
+	}
+	now := time.Now()
+	for _, target := range w.targets {
+		state := w.state.target(target)
+		for _, item := range added {
+			state.Deliveries[item.Source] = &webhookDelivery{
+				Status:  webhookPending,
+				Found:   now,
+				Updated: now,
+				Payload: &webhookPayload{Event: webhookEventAdded, Vault: vault, Item: item},
+			}
+		}
+	}
+
+	w.save()
+	if len(added) > 0 {
+		select {
+		case w.queued <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// save writes the state file; the caller holds w.mu
+func (w *Webhooks) save() {
+	if err := saveState(w.stateFile, w.state); err != nil {
+		slog.Error("Failed to save webhook state", "error", err)
+	}
+}
+
+// Run delivers the queued clippings until ctx is done. Failed deliveries are retried with
+// exponential backoff, up to the configured number of attempts.
+func (w *Webhooks) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		wait, ok := w.deliverDue(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		timer.Stop()
+		if ok {
+			timer.Reset(wait)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.queued:
+		case <-timer.C:
+		}
+	}
+}
+
+type webhookJob struct {
+	target  string
+	source  string
+	found   time.Time
+	payload webhookPayload
+}
+
+// deliverDue sends every pending delivery that is due, oldest first, and returns how long
+// until the next retry is due, if any
+func (w *Webhooks) deliverDue(ctx context.Context) (time.Duration, bool) {
+	now := time.Now()
+	var jobs []webhookJob
+	w.mu.Lock()
+	for _, target := range w.targets {
+		state := w.state.Targets[webhookKey(target)]
+		if state == nil {
+			continue
+		}
+		for source, delivery := range state.Deliveries {
+			if delivery.Status == webhookPending && delivery.Payload != nil && !delivery.next.After(now) {
+				jobs = append(jobs, webhookJob{target, source, delivery.Found, *delivery.Payload})
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	slices.SortFunc(jobs, func(a, b webhookJob) int {
+		return cmp.Or(a.found.Compare(b.found), cmp.Compare(a.target, b.target), cmp.Compare(a.source, b.source))
+	})
+
+	for _, job := range jobs {
+		retry, err := w.send(ctx, job.target, &job.payload)
+		if ctx.Err() != nil {
+			// Interrupted by shutdown, the delivery is resumed after a restart
+			return 0, false
+		}
+		w.finish(job, retry, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var next time.Time
+	for _, state := range w.state.Targets {
+		for _, delivery := range state.Deliveries {
+			if delivery.Status == webhookPending && (next.IsZero() || delivery.next.Before(next)) {
+				next = delivery.next
+			}
+		}
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return max(time.Until(next), 0), true
+}
+
+// finish records the outcome of an attempt to deliver job
+func (w *Webhooks) finish(job webhookJob, retry bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delivery := w.state.target(job.target).Deliveries[job.source]
+	if delivery == nil {
+		return
+	}
+	delivery.Attempts++
+	delivery.Updated = time.Now()
+
+	switch {
+	case err == nil:
+		delivery.Status = webhookDelivered
+		delivery.Error = ""
+		delivery.Payload = nil
+		slog.Info("Delivered webhook", "target", webhookName(job.target), "source", job.source)
+	case retry && delivery.Attempts < w.maxAttempts:
+		delivery.Error = err.Error()
+		delivery.next = delivery.Updated.Add(w.backoff(delivery.Attempts))
+		slog.Warn("Failed to deliver webhook, retrying", "target", webhookName(job.target), "source", job.source,
+			"attempts", delivery.Attempts, "retryAt", delivery.next, "error", err)
+	default:
+		delivery.Status = webhookFailed
+		delivery.Error = err.Error()
+		delivery.Payload = nil
+		slog.Error("Failed to deliver webhook", "target", webhookName(job.target), "source", job.source,
+			"attempts", delivery.Attempts, "error", err)
+	}
+
+	w.save()
+}
+
+// backoff returns the delay before the attempt following attempts failed ones
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	delay := w.retryDelay
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= webhookMaxRetryDelay {
+			return webhookMaxRetryDelay
+		}
+	}
+	return delay
+}
+
+// send posts payload to target. retry reports whether a failure is worth retrying: network
+// errors, 429 and server errors are, anything else the target rejected is not.
+func (w *Webhooks) send(ctx context.Context, target string, payload *webhookPayload) (retry bool, err error) {
+	body, err := w.body(payload)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", w.contentType)
+	req.Header.Set("User-Agent", "obsidian-clippings-feed")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		// The error would otherwise repeat the URL of the target
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, fmt.Errorf("failed to post webhook: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded with %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+}
+
+// body renders payload with the configured template, or as JSON without one
+func (w *Webhooks) body(payload *webhookPayload) ([]byte, error) {
+	if w.template == nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+		}
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, payload); err != nil {
+		return nil, fmt.Errorf("failed to render webhook template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
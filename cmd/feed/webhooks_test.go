@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+// webhookReceiver records the requests posted to it and answers with the queued statuses,
+// then 200
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+	types    []string
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.bodies = append(r.bodies, string(body))
+	r.types = append(r.types, req.Header.Get("Content-Type"))
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.bodies...)
+}
+
+func newWebhookTestVaults(t *testing.T, dir string, config Config) *VaultSet {
+	t.Helper()
+
+	config.TargetDir = dir
+	config.MaxItems = 50
+	config.WebhookContentType = "application/json"
+	config.WebhookMaxAttempts = 5
+	config.WebhookRetryDelay = time.Millisecond
+	vaults, err := NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	return vaults
+}
+
+func deliveryStatus(w *Webhooks, target, source string) (string, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delivery := w.state.target(target).Deliveries[source]
+	if delivery == nil {
+		return "", 0
+	}
+	return delivery.Status, delivery.Attempts
+}
+
+func TestWebhooks(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	target := server.URL + "/hooks/secret"
+
+	dir := t.TempDir()
+	created := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	existing := clippingsfeed.Metadata{Title: "Existing", Source: "https://example.com/existing", Created: created}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{existing})
+
+	config := Config{Webhooks: []string{target}, StateDir: filepath.Join(t.TempDir(), "state")}
+	vaults := newWebhookTestVaults(t, dir, config)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	vaults.StartWebhooks(ctx)
+
+	added := clippingsfeed.Metadata{Title: "Added", Source: "https://example.com/added", Site: "Example", Created: created}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{added})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	waitFor(t, func() bool { return len(receiver.received()) == 1 })
+	var payload struct {
+		Event string         `json:"event"`
+		Item  map[string]any `json:"item"`
+	}
+	if err := json.Unmarshal([]byte(receiver.received()[0]), &payload); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if payload.Event != webhookEventAdded || payload.Item["source"] != added.Source || payload.Item["site"] != "Example" {
+		t.Errorf("Expected the added clipping, got %+v", payload)
+	}
+
+	// Editing a clipping neither sends it again nor saves the state
+	waitFor(t, func() bool {
+		status, _ := deliveryStatus(vaults.webhooks, target, added.Source)
+		return status == webhookDelivered
+	})
+	stateFile := filepath.Join(config.StateDir, "webhooks.json")
+	saved, err := os.Stat(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to stat webhook state: %v", err)
+	}
+	added.Title = "Added and edited"
+	if err := os.WriteFile(filepath.Join(dir, "Added.md"), []byte(createMarkdownContent(added)), 0644); err != nil {
+		t.Fatalf("Failed to edit note: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	vaults.webhooks.deliverDue(t.Context())
+	if info, err := os.Stat(stateFile); err != nil || !info.ModTime().Equal(saved.ModTime()) {
+		t.Errorf("Expected the state not to be saved again, got %v, %v", info, err)
+	}
+
+	cancel()
+	if err := vaults.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// The state keeps the secret in the URL of the target to the owner
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to read webhook state: %v", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("Expected the state not to contain the URL of the target, got %s", data)
+	}
+	if info, err := os.Stat(stateFile); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the state to be private, got %v, %v", info, err)
+	}
+
+	// A restart neither resends delivered clippings nor sends the existing ones
+	restarted := newWebhookTestVaults(t, dir, config)
+	restarted.webhooks.deliverDue(t.Context())
+	if got := receiver.received(); len(got) != 1 {
+		t.Errorf("Expected a single delivery, got %v", got)
+	}
+	if status, _ := deliveryStatus(restarted.webhooks, target, existing.Source); status != "" || !restarted.webhooks.state.Known[existing.Source] {
+		t.Errorf("Expected the existing clipping to be known but not queued, got %q", status)
+	}
+	if status, attempts := deliveryStatus(restarted.webhooks, target, added.Source); status != webhookDelivered || attempts != 1 {
+		t.Errorf("Expected the added clipping to be delivered once, got %q after %d attempts", status, attempts)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	retried := httptest.NewServer(&webhookReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}})
+	defer retried.Close()
+	rejected := httptest.NewServer(&webhookReceiver{statuses: []int{http.StatusBadRequest}})
+	defer rejected.Close()
+
+	dir := t.TempDir()
+	config := Config{
+		Webhooks: []string{retried.URL, rejected.URL},
+		StateDir: t.TempDir(),
+	}
+	vaults := newWebhookTestVaults(t, dir, config)
+
+	added := clippingsfeed.Metadata{Title: "Added", Source: "https://example.com/added", Created: time.Now()}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{added})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	vaults.StartWebhooks(ctx)
+
+	// 503 and 429 are retried, 400 is not
+	waitFor(t, func() bool {
+		status, _ := deliveryStatus(vaults.webhooks, retried.URL, added.Source)
+		return status == webhookDelivered
+	})
+	if _, attempts := deliveryStatus(vaults.webhooks, retried.URL, added.Source); attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if status, attempts := deliveryStatus(vaults.webhooks, rejected.URL, added.Source); status != webhookFailed || attempts != 1 {
+		t.Errorf("Expected the rejected delivery to fail at once, got %q after %d attempts", status, attempts)
+	}
+}
+
+func TestWebhookResumeAfterRestart(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusBadGateway}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	target := server.URL + "/hook"
+
+	dir := t.TempDir()
+	config := Config{Webhooks: []string{target}, StateDir: t.TempDir()}
+	vaults := newWebhookTestVaults(t, dir, config)
+	vaults.webhooks.retryDelay = time.Hour
+
+	added := clippingsfeed.Metadata{Title: "Added", Source: "https://example.com/added", Created: time.Now()}
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{added})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	if wait, ok := vaults.webhooks.deliverDue(t.Context()); !ok || wait < 59*time.Minute {
+		t.Errorf("Expected a retry in an hour, got %v (ok=%v)", wait, ok)
+	}
+	if status, _ := deliveryStatus(vaults.webhooks, target, added.Source); status != webhookPending {
+		t.Fatalf("Expected the delivery to be pending, got %q", status)
+	}
+
+	// The pending delivery is resumed from the state file
+	restarted, err := NewWebhooks(config)
+	if err != nil {
+		t.Fatalf("NewWebhooks failed: %v", err)
+	}
+	if _, ok := restarted.deliverDue(t.Context()); ok {
+		t.Error("Expected nothing left to retry")
+	}
+	if status, attempts := deliveryStatus(restarted, target, added.Source); status != webhookDelivered || attempts != 2 {
+		t.Errorf("Expected the delivery to succeed on the second attempt, got %q after %d attempts", status, attempts)
+	}
+	if got := receiver.received(); len(got) != 2 || got[0] != got[1] {
+		t.Errorf("Expected the same payload twice, got %v", got)
+	}
+}
+
+func TestWebhookStateOfEarlierVersions(t *testing.T) {
+	target := "https://hooks.example.com/services/secret"
+	state := t.TempDir()
+	legacy := `{"targets": {"` + target + `": {"vaults": [""], "deliveries": {"https://example.com/added": {"status": "delivered"}}}}}`
+	if err := os.WriteFile(filepath.Join(state, "webhooks.json"), []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write webhook state: %v", err)
+	}
+
+	w, err := NewWebhooks(Config{Webhooks: []string{target}, StateDir: state})
+	if err != nil {
+		t.Fatalf("NewWebhooks failed: %v", err)
+	}
+	if status, _ := deliveryStatus(w, target, "https://example.com/added"); status != webhookDelivered {
+		t.Errorf("Expected the state of earlier versions to be kept, got %q", status)
+	}
+	if _, ok := w.state.Targets[target]; ok {
+		t.Error("Expected the state not to be keyed by the URL of the target anymore")
+	}
+	if !slices.Contains(w.state.Vaults, "") || !w.state.Known["https://example.com/added"] {
+		t.Errorf("Expected the clippings tracked by the target to be known, got %+v", w.state.clippingTracker)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	templateFile := filepath.Join(t.TempDir(), "slack.tmpl")
+	if err := os.WriteFile(templateFile, []byte(`{"text": {{json (printf "New clipping: %s <%s>" .Item.Title .Item.Source)}}}`), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	dir := t.TempDir()
+	config := Config{
+		Webhooks:        []string{server.URL},
+		WebhookTemplate: templateFile,
+		StateDir:        t.TempDir(),
+	}
+	vaults := newWebhookTestVaults(t, dir, config)
+	vaults.webhooks.contentType = "application/vnd.example+json"
+
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "Tabs\tand <brackets>", Source: "https://example.com/quotes", Created: time.Now()}})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	vaults.webhooks.deliverDue(t.Context())
+
+	got := receiver.received()
+	expected := `{"text": "New clipping: Tabs\tand \u003cbrackets\u003e \u003chttps://example.com/quotes\u003e"}`
+	if len(got) != 1 || got[0] != expected {
+		t.Errorf("Expected %s, got %v", expected, got)
+	}
+	if receiver.types[0] != "application/vnd.example+json" {
+		t.Errorf("Expected the configured content type, got %q", receiver.types[0])
+	}
+}
+
+func TestNewWebhooksErrors(t *testing.T) {
+	state := t.TempDir()
+	if err := os.WriteFile(filepath.Join(state, "webhooks.json"), []byte("not json"), 0644); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	tests := map[string]Config{
+		"relative URL":     {Webhooks: []string{"/hook"}, StateDir: t.TempDir()},
+		"unsupported URL":  {Webhooks: []string{"ftp://example.com/hook"}, StateDir: t.TempDir()},
+		"missing template": {Webhooks: []string{"https://example.com/hook"}, StateDir: t.TempDir(), WebhookTemplate: filepath.Join(t.TempDir(), "missing.tmpl")},
+		"corrupt state":    {Webhooks: []string{"https://example.com/hook"}, StateDir: state},
+		"no state dir":     {Webhooks: []string{"https://example.com/hook"}},
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewWebhooks(config); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+
+	if webhooks, err := NewWebhooks(Config{}); webhooks != nil || err != nil {
+		t.Errorf("Expected no webhooks without targets, got %v, %v", webhooks, err)
+	}
+}
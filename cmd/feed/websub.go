@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	webSubTimeout      = 10 * time.Second
+	webSubDefaultLease = 10 * 24 * time.Hour
+	webSubMaxLease     = 365 * 24 * time.Hour
+	webSubMaxSecret    = 200
+
+	// webSubMaxVerifications and webSubMaxDeliveries bound the requests the hub makes at once,
+	// webSubMaxPings the pings of an external hub
+	webSubMaxVerifications = 8
+	webSubMaxDeliveries    = 8
+	webSubMaxPings         = 4
+)
+
+// feedURL returns the URL of the output name of the feeds served at feedLink
+func feedURL(feedLink, name string) string {
+	return strings.TrimRight(feedLink, "/") + "/" + name
+}
+
+// feedLinkHeader advertises hub and self as the WebSub discovery Link header of a feed
+func feedLinkHeader(hub, self string) string {
+	return fmt.Sprintf(`<%s>; rel="hub", <%s>; rel="self"`, hub, self)
+}
+
+// webSubPublisher is told about the feeds of every generation: the built-in hub, or the pinger
+// of an external one
+type webSubPublisher interface {
+	// Publish makes output the content of topic, which changed when distribute is set
+	Publish(topic string, output *Output, distribute bool)
+}
+
+// newWebSubNotifier returns a notify function for a generator publishing with config. It hands
+// the feeds of every generation to hub, once per feed, telling whether they differ from those of
+// the previous one.
+func newWebSubNotifier(config Config, hub webSubPublisher) func(gen *Generation) {
+	var mu sync.Mutex
+	var previous string
+
+	return func(gen *Generation) {
+		mu.Lock()
+		changed := previous != "" && previous != gen.feedDigest
+		previous = gen.feedDigest
+		mu.Unlock()
+
+		for _, format := range []string{"rss", "atom", "json"} {
+			name := "feed." + format
+			output, ok := gen.Outputs[name]
+			if !ok {
+				continue
+			}
+			hub.Publish(feedURL(config.FeedLink, name), output, changed)
+		}
+	}
+}
+
+// webSubPinger tells an external hub about updated feeds, so it fetches and distributes them
+type webSubPinger struct {
+	hub    string
+	client *http.Client
+
+	// ctx is cancelled to abort the pings in flight when shutting down
+	ctx    context.Context
+	cancel context.CancelFunc
+	// pings holds a slot per ping in flight, tasks tracks them
+	pings chan struct{}
+	tasks sync.WaitGroup
+}
+
+func newWebSubPinger(hub string) *webSubPinger {
+	p := &webSubPinger{
+		hub:    hub,
+		client: &http.Client{Timeout: webSubTimeout},
+		pings:  make(chan struct{}, webSubMaxPings),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+// Publish pings the hub in the background when topic changed, once fewer than webSubMaxPings
+// pings are in flight
+func (p *webSubPinger) Publish(topic string, _ *Output, changed bool) {
+	if !changed {
+		return
+	}
+	p.tasks.Add(1)
+	go func() {
+		defer p.tasks.Done()
+		select {
+		case p.pings <- struct{}{}:
+			defer func() { <-p.pings }()
+			p.ping(topic)
+		case <-p.ctx.Done():
+		}
+	}()
+}
+
+// ping tells the hub that topic was updated
+func (p *webSubPinger) ping(topic string) {
+	form := url.Values{"hub.mode": {"publish"}, "hub.url": {topic}}
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodPost, p.hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		slog.Error("Failed to create WebSub ping", "hub", p.hub, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		slog.Error("Failed to ping WebSub hub", "hub", p.hub, "topic", topic, "error", err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Error("Failed to ping WebSub hub", "hub", p.hub, "topic", topic, "status", resp.Status)
+		return
+	}
+	slog.Debug("Pinged WebSub hub", "hub", p.hub, "topic", topic)
+}
+
+type webSubSubscription struct {
+	Topic    string    `json:"topic"`
+	Callback string    `json:"callback"`
+	Secret   string    `json:"secret,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// WebSubHub is a minimal WebSub hub for the feeds of this server. It verifies the intent of
+// subscribers, pushes the new content of a feed to them whenever it changes, and keeps its
+// subscriptions in a state file across restarts.
+type WebSubHub struct {
+	url       string
+	stateFile string
+	client    *http.Client
+
+	// ctx is cancelled to abort the requests in flight when shutting down
+	ctx    context.Context
+	cancel context.CancelFunc
+	// verifications and deliveries hold a slot per request in flight, tasks tracks them
+	verifications chan struct{}
+	deliveries    chan struct{}
+	tasks         sync.WaitGroup
+
+	mu sync.Mutex
+	// topics holds the current content of every feed, by URL
+	topics map[string]*Output
+	// subscriptions is keyed by topic and callback
+	subscriptions map[[2]string]*webSubSubscription
+}
+
+// NewWebSubHub creates the built-in hub served at hubURL
+func NewWebSubHub(hubURL, stateFile string) (*WebSubHub, error) {
+	h := &WebSubHub{
+		url:           hubURL,
+		stateFile:     stateFile,
+		client:        &http.Client{Timeout: webSubTimeout},
+		verifications: make(chan struct{}, webSubMaxVerifications),
+		deliveries:    make(chan struct{}, webSubMaxDeliveries),
+		topics:        make(map[string]*Output),
+		subscriptions: make(map[[2]string]*webSubSubscription),
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+
+	var subscriptions []*webSubSubscription
+	if _, err := loadState(stateFile, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to load WebSub state: %w", err)
+	}
+	for _, sub := range subscriptions {
+		h.subscriptions[[2]string{sub.Topic, sub.Callback}] = sub
+	}
+
+	return h, nil
+}
+
+// save writes the subscriptions to the state file; the caller holds h.mu
+func (h *WebSubHub) save() {
+	subscriptions := make([]*webSubSubscription, 0, len(h.subscriptions))
+	for _, sub := range h.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	if err := saveState(h.stateFile, subscriptions); err != nil {
+		slog.Error("Failed to save WebSub state", "error", err)
+	}
+}
+
+// Publish makes output the content of topic. When distribute is set, it is pushed to the
+// subscribers of topic.
+func (h *WebSubHub) Publish(topic string, output *Output, distribute bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.topics[topic] = output
+	if !distribute {
+		return
+	}
+
+	now := time.Now()
+	expired := false
+	for key, sub := range h.subscriptions {
+		if sub.Topic != topic {
+			continue
+		}
+		if now.After(sub.Expires) {
+			delete(h.subscriptions, key)
+			expired = true
+			continue
+		}
+		h.deliverAsync(*sub, output)
+	}
+	if expired {
+		h.save()
+	}
+}
+
+// deliverAsync delivers the content of a topic to a subscriber in the background, once fewer
+// than webSubMaxDeliveries deliveries are in flight
+func (h *WebSubHub) deliverAsync(sub webSubSubscription, output *Output) {
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		select {
+		case h.deliveries <- struct{}{}:
+			defer func() { <-h.deliveries }()
+			h.deliver(sub, output)
+		case <-h.ctx.Done():
+		}
+	}()
+}
+
+// deliver pushes the content of a topic to a subscriber, signed with its secret if it has one
+func (h *WebSubHub) deliver(sub webSubSubscription, output *Output) {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodPost, sub.Callback, bytes.NewReader(output.Body))
+	if err != nil {
+		slog.Error("Failed to create WebSub delivery", "topic", sub.Topic, "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", output.ContentType)
+	req.Header.Set("Link", feedLinkHeader(h.url, sub.Topic))
+	if sub.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(sub.Secret))
+		mac.Write(output.Body)
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		slog.Error("Failed to deliver WebSub content", "topic", sub.Topic, "callback", webhookName(sub.Callback), "error", err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		// The subscriber does not want the content anymore
+		h.mu.Lock()
+		delete(h.subscriptions, [2]string{sub.Topic, sub.Callback})
+		h.save()
+		h.mu.Unlock()
+		slog.Info("Removed gone WebSub subscription", "topic", sub.Topic, "callback", webhookName(sub.Callback))
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		slog.Error("Failed to deliver WebSub content", "topic", sub.Topic, "callback", webhookName(sub.Callback), "status", resp.Status)
+	default:
+		slog.Debug("Delivered WebSub content", "topic", sub.Topic, "callback", webhookName(sub.Callback))
+	}
+}
+
+// ServeHTTP accepts subscription requests. The hub answers 202 and verifies the intent of the
+// subscriber asynchronously, as WebSub requires.
+func (h *WebSubHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	mode := r.PostForm.Get("hub.mode")
+	if mode != "subscribe" && mode != "unsubscribe" {
+		http.Error(w, "hub.mode must be subscribe or unsubscribe", http.StatusBadRequest)
+		return
+	}
+
+	sub := webSubSubscription{
+		Topic:    r.PostForm.Get("hub.topic"),
+		Callback: r.PostForm.Get("hub.callback"),
+		Secret:   r.PostForm.Get("hub.secret"),
+	}
+	callback, err := url.Parse(sub.Callback)
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		http.Error(w, "hub.callback must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	h.mu.Lock()
+	_, known := h.topics[sub.Topic]
+	h.mu.Unlock()
+	if !known {
+		http.Error(w, "hub.topic is not a feed of this hub", http.StatusBadRequest)
+		return
+	}
+	if len(sub.Secret) >= webSubMaxSecret {
+		http.Error(w, "hub.secret is too long", http.StatusBadRequest)
+		return
+	}
+
+	lease := webSubDefaultLease
+	if seconds, err := strconv.Atoi(r.PostForm.Get("hub.lease_seconds")); err == nil && seconds > 0 {
+		lease = min(time.Duration(seconds)*time.Second, webSubMaxLease)
+	}
+
+	select {
+	case h.verifications <- struct{}{}:
+	default:
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "too many pending verifications", http.StatusServiceUnavailable)
+		return
+	}
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		defer func() { <-h.verifications }()
+		h.verify(mode, sub, lease)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify confirms with the subscriber that it requested mode before applying it
+func (h *WebSubHub) verify(mode string, sub webSubSubscription, lease time.Duration) {
+	challenge := make([]byte, 16)
+	_, _ = rand.Read(challenge)
+
+	callback, err := url.Parse(sub.Callback)
+	if err != nil {
+		return
+	}
+	query := callback.Query()
+	query.Set("hub.mode", mode)
+	query.Set("hub.topic", sub.Topic)
+	query.Set("hub.challenge", hex.EncodeToString(challenge))
+	if mode == "subscribe" {
+		query.Set("hub.lease_seconds", strconv.Itoa(int(lease.Seconds())))
+	}
+	callback.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodGet, callback.String(), nil)
+	if err != nil {
+		return
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to verify WebSub intent", "mode", mode, "topic", sub.Topic, "callback", webhookName(sub.Callback), "error", err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode >= 300 || strings.TrimSpace(string(body)) != hex.EncodeToString(challenge) {
+		slog.Warn("WebSub subscriber did not confirm its intent", "mode", mode, "topic", sub.Topic, "callback", webhookName(sub.Callback), "status", resp.Status)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := [2]string{sub.Topic, sub.Callback}
+	if mode == "unsubscribe" {
+		delete(h.subscriptions, key)
+	} else {
+		sub.Expires = time.Now().Add(lease)
+		h.subscriptions[key] = &sub
+	}
+	h.save()
+	slog.Info("Verified WebSub intent", "mode", mode, "topic", sub.Topic, "callback", webhookName(sub.Callback))
+}
+
+// wait waits for the pings in flight to finish. When ctx is done first, they are cancelled.
+func (p *webSubPinger) wait(ctx context.Context) error {
+	return waitTasks(ctx, &p.tasks, p.cancel)
+}
+
+// wait waits for the verifications and deliveries in flight to finish. When ctx is done first,
+// they are cancelled.
+func (h *WebSubHub) wait(ctx context.Context) error {
+	return waitTasks(ctx, &h.tasks, h.cancel)
+}
+
+// waitTasks waits for tasks to finish. When ctx is done first, cancel aborts them.
+func waitTasks(ctx context.Context, tasks *sync.WaitGroup, cancel context.CancelFunc) error {
+	done := make(chan struct{})
+	go func() {
+		tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+// subscribers returns the number of active subscriptions to topic
+func (h *WebSubHub) subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for _, sub := range h.subscriptions {
+		if sub.Topic == topic && time.Now().Before(sub.Expires) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+)
+
+func TestWebSubPing(t *testing.T) {
+	var mu sync.Mutex
+	var pinged []string
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("hub.mode") != "publish" {
+			t.Errorf("Unexpected ping %v: %v", r.PostForm, err)
+		}
+		mu.Lock()
+		pinged = append(pinged, r.PostForm.Get("hub.url"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hub.Close()
+	pings := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), pinged...)
+	}
+
+	dir := t.TempDir()
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+	vaults, err := NewVaultSet(Config{TargetDir: dir, MaxItems: 50, FeedLink: "https://feeds.example.com/", WebSubHub: hub.URL})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	// The feeds advertise the hub in their body and in a Link header
+	tests := map[string]string{
+		"feed.rss":  `<atom:link href="https://feeds.example.com/feed.rss" rel="self" type="application/rss+xml"></atom:link>`,
+		"feed.atom": `<link href="https://feeds.example.com/feed.atom" rel="self" type="application/atom+xml"></link>`,
+		"feed.json": `"feed_url": "https://feeds.example.com/feed.json"`,
+	}
+	for name, self := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+		body := rec.Body.String()
+		if !strings.Contains(body, self) || !strings.Contains(body, hub.URL) {
+			t.Errorf("Expected %s to link itself and the hub, got %s", name, body)
+		}
+		expected := `<` + hub.URL + `>; rel="hub", <https://feeds.example.com/` + name + `>; rel="self"`
+		if got := rec.Header().Get("Link"); got != expected {
+			t.Errorf("Expected Link %q for %s, got %q", expected, name, got)
+		}
+	}
+
+	// A regeneration without changes to the items pings nothing
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "Second", Source: "https://example.com/second", Created: time.Now()}})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	// Shutdown waits for the pings in flight
+	if err := vaults.Shutdown(t.Context()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if len(pings()) != 3 {
+		t.Fatalf("Expected 3 pings, got %v", pings())
+	}
+
+	got := strings.Join(pings(), " ")
+	for name := range tests {
+		if !strings.Contains(got, "https://feeds.example.com/"+name) {
+			t.Errorf("Expected a ping for %s, got %s", name, got)
+		}
+	}
+}
+
+// webSubSubscriber confirms the intents it expects and records the content pushed to it
+type webSubSubscriber struct {
+	mu       sync.Mutex
+	verified []url.Values
+	pushed   []*http.Request
+	bodies   []string
+}
+
+func (s *webSubSubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == http.MethodGet {
+		if r.URL.Path == "/refuse" {
+			http.NotFound(w, r)
+			return
+		}
+		s.verified = append(s.verified, r.URL.Query())
+		_, _ = io.WriteString(w, r.URL.Query().Get("hub.challenge"))
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	s.pushed = append(s.pushed, r)
+	s.bodies = append(s.bodies, string(body))
+}
+
+func (s *webSubSubscriber) count() (verified, pushed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.verified), len(s.pushed)
+}
+
+func postHub(t *testing.T, handler http.Handler, form url.Values) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/hub", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWebSubBuiltinHub(t *testing.T) {
+	subscriber := &webSubSubscriber{}
+	server := httptest.NewServer(subscriber)
+	defer server.Close()
+
+	dir := t.TempDir()
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+	config := Config{
+		TargetDir:        dir,
+		MaxItems:         50,
+		FeedLink:         "https://feeds.example.com/",
+		WebSubBuiltinHub: true,
+		StateDir:         t.TempDir(),
+	}
+	stateless := config
+	stateless.StateDir = ""
+	if _, err := NewVaultSet(stateless); err == nil {
+		t.Error("Expected the built-in hub to require a state directory")
+	}
+
+	vaults, err := NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/feed.atom", nil))
+	if !strings.Contains(rec.Body.String(), `<link href="https://feeds.example.com/hub" rel="hub"></link>`) {
+		t.Errorf("Expected the feed to link the built-in hub, got %s", rec.Body.String())
+	}
+
+	topic := "https://feeds.example.com/feed.atom"
+	subscribe := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {topic},
+		"hub.callback":      {server.URL + "/callback?feed=clippings"},
+		"hub.secret":        {"s3cret"},
+		"hub.lease_seconds": {"3600"},
+	}
+	if code := postHub(t, handler, subscribe); code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", code)
+	}
+	waitFor(t, func() bool { return vaults.hub.subscribers(topic) == 1 })
+
+	subscriber.mu.Lock()
+	verification := subscriber.verified[0]
+	subscriber.mu.Unlock()
+	if verification.Get("feed") != "clippings" || verification.Get("hub.topic") != topic || verification.Get("hub.lease_seconds") != "3600" {
+		t.Errorf("Unexpected verification %v", verification)
+	}
+
+	invalid := map[string]url.Values{
+		"unknown topic":    {"hub.mode": {"subscribe"}, "hub.topic": {"https://feeds.example.com/other.rss"}, "hub.callback": {server.URL}},
+		"invalid callback": {"hub.mode": {"subscribe"}, "hub.topic": {topic}, "hub.callback": {"/callback"}},
+		"unsupported mode": {"hub.mode": {"publish"}, "hub.url": {topic}},
+	}
+	for name, form := range invalid {
+		if code := postHub(t, handler, form); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, code)
+		}
+	}
+
+	// A subscriber that does not confirm the intent is not subscribed
+	refused := url.Values{"hub.mode": {"subscribe"}, "hub.topic": {"https://feeds.example.com/feed.rss"}, "hub.callback": {server.URL + "/refuse"}}
+	if code := postHub(t, handler, refused); code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", code)
+	}
+
+	// New clippings are pushed with the signature of the secret
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "Second", Source: "https://example.com/second", Created: time.Now()}})
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	waitFor(t, func() bool { _, pushed := subscriber.count(); return pushed == 1 })
+
+	subscriber.mu.Lock()
+	pushed, body := subscriber.pushed[0], subscriber.bodies[0]
+	subscriber.mu.Unlock()
+	if !strings.Contains(body, "Second") || pushed.Header.Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Expected the new Atom feed, got %s %s", pushed.Header.Get("Content-Type"), body)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(body))
+	if got := pushed.Header.Get("X-Hub-Signature"); got != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Unexpected signature %q", got)
+	}
+	if got := pushed.Header.Get("Link"); !strings.Contains(got, `<https://feeds.example.com/hub>; rel="hub"`) {
+		t.Errorf("Unexpected Link %q", got)
+	}
+	if got := vaults.hub.subscribers("https://feeds.example.com/feed.rss"); got != 0 {
+		t.Errorf("Expected the refused subscription to be dropped, got %d", got)
+	}
+
+	// The state holds the secret, so only the owner can read it
+	if info, err := os.Stat(filepath.Join(config.StateDir, "websub.json")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected the state to be private, got %v, %v", info, err)
+	}
+
+	// Subscriptions survive a restart
+	restarted, err := NewVaultSet(config)
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if got := restarted.hub.subscribers(topic); got != 1 {
+		t.Errorf("Expected the subscription to be restored, got %d", got)
+	}
+
+	subscribe.Set("hub.mode", "unsubscribe")
+	if code := postHub(t, handler, subscribe); code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", code)
+	}
+	waitFor(t, func() bool { return vaults.hub.subscribers(topic) == 0 })
+}
+
+func TestWebSubHubLimits(t *testing.T) {
+	// The subscriber never answers the verifications, until they are cancelled
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	defer close(release)
+
+	dir := t.TempDir()
+	writeVaultNotes(t, dir, []clippingsfeed.Metadata{{Title: "First", Source: "https://example.com/first", Created: time.Now()}})
+	vaults, err := NewVaultSet(Config{
+		TargetDir:        dir,
+		MaxItems:         50,
+		FeedLink:         "https://feeds.example.com/",
+		WebSubBuiltinHub: true,
+		StateDir:         t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewVaultSet failed: %v", err)
+	}
+	if err := vaults.GenerateFeeds(t.Context()); err != nil {
+		t.Fatalf("GenerateFeeds failed: %v", err)
+	}
+	handler := vaults.Handler()
+
+	subscribe := func(i int) int {
+		return postHub(t, handler, url.Values{
+			"hub.mode":     {"subscribe"},
+			"hub.topic":    {"https://feeds.example.com/feed.atom"},
+			"hub.callback": {server.URL + "/callback/" + strconv.Itoa(i)},
+		})
+	}
+	for i := range webSubMaxVerifications {
+		if code := subscribe(i); code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d", code)
+		}
+	}
+	if code := subscribe(webSubMaxVerifications); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once too many verifications are pending, got %d", code)
+	}
+
+	// Shutdown cancels the requests still in flight once its deadline passes
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if err := vaults.Shutdown(ctx); err == nil {
+		t.Error("Expected Shutdown to report the unfinished verifications")
+	}
+	if err := vaults.hub.wait(t.Context()); err != nil {
+		t.Errorf("Expected the verifications to be cancelled, got %v", err)
+	}
+}
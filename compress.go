@@ -0,0 +1,56 @@
+package clippingsfeed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encoding describes a content-coding that generated outputs are pre-compressed with
+type Encoding struct {
+	// Name is the Content-Encoding token, e.g. "gzip"
+	Name string
+	// Extension is appended to the file name of the compressed variant, e.g. ".gz"
+	Extension string
+
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// Encodings lists the supported content-codings in order of preference
+var Encodings = []Encoding{
+	{
+		Name:      "br",
+		Extension: ".br",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+		},
+	},
+	{
+		Name:      "gzip",
+		Extension: ".gz",
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+	},
+}
+
+// Compress returns data compressed with the given encoding
+func Compress(data []byte, encoding Encoding) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := encoding.newWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s writer: %w", encoding.Name, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress with %s: %w", encoding.Name, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish %s stream: %w", encoding.Name, err)
+	}
+
+	return buf.Bytes(), nil
+}
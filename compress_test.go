@@ -0,0 +1,45 @@
+package clippingsfeed_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func decompress(t *testing.T, name string, data []byte) []byte {
+	t.Helper()
+
+	var r io.Reader
+	switch name {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		assert.NilError(t, err)
+		r = gr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		t.Fatalf("unknown encoding %s", name)
+	}
+
+	result, err := io.ReadAll(r)
+	assert.NilError(t, err)
+	return result
+}
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("<item><title>clipping</title></item>\n"), 100)
+
+	for _, encoding := range clippingsfeed.Encodings {
+		t.Run(encoding.Name, func(t *testing.T) {
+			compressed, err := clippingsfeed.Compress(data, encoding)
+			assert.NilError(t, err)
+			assert.Assert(t, len(compressed) < len(data))
+			assert.DeepEqual(t, decompress(t, encoding.Name, compressed), data)
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package clippingsfeed
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -39,6 +41,29 @@ func SortMetadataByCreated(metadata []Metadata) {
 	})
 }
 
+// DeduplicateMetadataBySource keeps one item per Source, the earliest created one, so an
+// article clipped into several vaults appears once. Items without a Source are kept as is.
+func DeduplicateMetadataBySource(metadata []Metadata) []Metadata {
+	var deduplicated []Metadata
+	seen := make(map[string]int)
+	for _, meta := range metadata {
+		if meta.Source == "" {
+			deduplicated = append(deduplicated, meta)
+			continue
+		}
+		i, ok := seen[meta.Source]
+		if !ok {
+			seen[meta.Source] = len(deduplicated)
+			deduplicated = append(deduplicated, meta)
+			continue
+		}
+		if meta.Created.Before(deduplicated[i].Created) {
+			deduplicated[i] = meta
+		}
+	}
+	return deduplicated
+}
+
 // LimitMetadataItems limits the number of items if maxItems is specified and positive
 func LimitMetadataItems(metadata []Metadata, maxItems int) []Metadata {
 	if maxItems > 0 && len(metadata) > maxItems {
@@ -100,6 +125,107 @@ func GenerateFeed(metadata []Metadata, config FeedConfig) (*feeds.Feed, error) {
 	return feed, nil
 }
 
+// WriteFeed writes feed to w in the given format ("rss", "atom" or "json")
+func WriteFeed(w io.Writer, feed *feeds.Feed, format string) error {
+	return WriteFeedWithLinks(w, feed, format, FeedLinks{})
+}
+
+// FeedLinks are the links a feed advertises about itself
+type FeedLinks struct {
+	// Self is the URL the feed is served at
+	Self string
+	// Hub is the WebSub hub readers subscribe to for updates of Self
+	Hub string
+}
+
+// atomNamespace is the namespace of the atom:link elements of an RSS feed
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+type rssAtomLink struct {
+	XMLName xml.Name `xml:"atom:link"`
+	Href    string   `xml:"href,attr"`
+	Rel     string   `xml:"rel,attr"`
+	Type    string   `xml:"type,attr,omitempty"`
+}
+
+type rssChannelWithLinks struct {
+	*feeds.RssFeed
+	AtomLinks []rssAtomLink
+}
+
+type rssWithLinks struct {
+	XMLName          xml.Name `xml:"rss"`
+	Version          string   `xml:"version,attr"`
+	ContentNamespace string   `xml:"xmlns:content,attr"`
+	AtomNamespace    string   `xml:"xmlns:atom,attr"`
+	Channel          *rssChannelWithLinks
+}
+
+func (r *rssWithLinks) FeedXml() interface{} {
+	return r
+}
+
+type atomWithLinks struct {
+	*feeds.AtomFeed
+	Links []feeds.AtomLink
+}
+
+func (a *atomWithLinks) FeedXml() interface{} {
+	return a
+}
+
+// WriteFeedWithLinks writes feed like WriteFeed, with links to itself and its WebSub hub: RSS
+// and Atom as link elements, JSON Feed as feed_url and hubs. Empty links are left out.
+func WriteFeedWithLinks(w io.Writer, feed *feeds.Feed, format string, links FeedLinks) error {
+	if links == (FeedLinks{}) {
+		switch format {
+		case "rss":
+			return feed.WriteRss(w)
+		case "atom":
+			return feed.WriteAtom(w)
+		case "json":
+			return feed.WriteJSON(w)
+		}
+	}
+
+	switch format {
+	case "rss":
+		channel := &rssChannelWithLinks{RssFeed: (&feeds.Rss{Feed: feed}).RssFeed()}
+		if links.Self != "" {
+			channel.AtomLinks = append(channel.AtomLinks, rssAtomLink{Href: links.Self, Rel: "self", Type: "application/rss+xml"})
+		}
+		if links.Hub != "" {
+			channel.AtomLinks = append(channel.AtomLinks, rssAtomLink{Href: links.Hub, Rel: "hub"})
+		}
+		return feeds.WriteXML(&rssWithLinks{
+			Version:          "2.0",
+			ContentNamespace: "http://purl.org/rss/1.0/modules/content/",
+			AtomNamespace:    atomNamespace,
+			Channel:          channel,
+		}, w)
+	case "atom":
+		atom := &atomWithLinks{AtomFeed: (&feeds.Atom{Feed: feed}).AtomFeed()}
+		if links.Self != "" {
+			atom.Links = append(atom.Links, feeds.AtomLink{Href: links.Self, Rel: "self", Type: "application/atom+xml"})
+		}
+		if links.Hub != "" {
+			atom.Links = append(atom.Links, feeds.AtomLink{Href: links.Hub, Rel: "hub"})
+		}
+		return feeds.WriteXML(atom, w)
+	case "json":
+		jsonFeed := (&feeds.JSON{Feed: feed}).JSONFeed()
+		jsonFeed.FeedUrl = links.Self
+		if links.Hub != "" {
+			jsonFeed.Hubs = []*feeds.JSONHub{{Type: "WebSub", Url: links.Hub}}
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(jsonFeed)
+	default:
+		return fmt.Errorf("unsupported feed format: %s (supported: rss, atom, json)", format)
+	}
+}
+
 func WriteFeedToFile(feed *feeds.Feed, filename string) error {
 	// Determine format from file extension
 	ext := strings.ToLower(filepath.Ext(filename))
@@ -115,28 +241,9 @@ func WriteFeedToFile(feed *feeds.Feed, filename string) error {
 		return fmt.Errorf("unsupported file extension: %s (supported: .rss, .atom, .json)", ext)
 	}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", filename, err)
-	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			// If there was already an error, keep it; otherwise use close error
-			if err == nil {
-				err = fmt.Errorf("failed to close file %s: %w", filename, closeErr)
-			}
-		}
-	}()
-
-	switch format {
-	case "rss":
-		err = feed.WriteRss(file)
-	case "atom":
-		err = feed.WriteAtom(file)
-	case "json":
-		err = feed.WriteJSON(file)
-	}
-
+	err := WriteFileAtomic(filename, func(w io.Writer) error {
+		return WriteFeed(w, feed, format)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to write %s feed to %s: %w", format, filename, err)
 	}
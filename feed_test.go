@@ -1,6 +1,9 @@
 package clippingsfeed_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"os"
 	"path/filepath"
 	"strings"
@@ -278,6 +281,26 @@ func TestSortMetadataByCreated(t *testing.T) {
 	assert.Equal(t, "Oldest", metadata[2].Title)
 }
 
+func TestDeduplicateMetadataBySource(t *testing.T) {
+	baseTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	metadata := []clippingsfeed.Metadata{
+		{Title: "Later Clipping", Source: "https://example.com/1", Created: baseTime.Add(time.Hour)},
+		{Title: "Other Article", Source: "https://example.com/2", Created: baseTime},
+		{Title: "Earlier Clipping", Source: "https://example.com/1", Created: baseTime},
+		{Title: "No Source", Created: baseTime},
+		{Title: "No Source Either", Created: baseTime},
+	}
+
+	result := clippingsfeed.DeduplicateMetadataBySource(metadata)
+
+	assert.Equal(t, 4, len(result))
+	assert.Equal(t, "Earlier Clipping", result[0].Title)
+	assert.Equal(t, "Other Article", result[1].Title)
+	assert.Equal(t, "No Source", result[2].Title)
+	assert.Equal(t, "No Source Either", result[3].Title)
+}
+
 func TestLimitMetadataItems(t *testing.T) {
 	baseTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
 
@@ -387,3 +410,82 @@ func TestWriteFeedToFileUnsupportedExtension(t *testing.T) {
 	err = clippingsfeed.WriteFeedToFile(feed, tmpFile)
 	assert.ErrorContains(t, err, "unsupported file extension: .xml")
 }
+
+func TestWriteFeedUnsupportedFormat(t *testing.T) {
+	feed, err := clippingsfeed.GenerateFeed(nil, clippingsfeed.FeedConfig{Title: "Test Feed"})
+	assert.NilError(t, err)
+
+	var buf strings.Builder
+	err = clippingsfeed.WriteFeed(&buf, feed, "xml")
+	assert.ErrorContains(t, err, "unsupported feed format: xml")
+}
+
+func TestWriteFeedWithLinks(t *testing.T) {
+	feed, err := clippingsfeed.GenerateFeed([]clippingsfeed.Metadata{
+		{Title: "Article", Source: "https://example.com/article", Created: time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)},
+	}, clippingsfeed.FeedConfig{Title: "Test Feed", Link: "https://feeds.example.com/"})
+	assert.NilError(t, err)
+
+	type link struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	}
+	links := clippingsfeed.FeedLinks{Self: "https://feeds.example.com/feed.rss", Hub: "https://hub.example.com/"}
+
+	t.Run("rss", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NilError(t, clippingsfeed.WriteFeedWithLinks(&buf, feed, "rss", links))
+
+		var rss struct {
+			Channel struct {
+				Title string   `xml:"title"`
+				Items []string `xml:"item>title"`
+				Links []link   `xml:"http://www.w3.org/2005/Atom link"`
+			} `xml:"channel"`
+		}
+		assert.NilError(t, xml.Unmarshal(buf.Bytes(), &rss))
+		assert.Equal(t, rss.Channel.Title, "Test Feed")
+		assert.DeepEqual(t, rss.Channel.Items, []string{"Article"})
+		assert.DeepEqual(t, rss.Channel.Links, []link{{links.Self, "self"}, {links.Hub, "hub"}})
+	})
+
+	t.Run("atom", func(t *testing.T) {
+		var buf bytes.Buffer
+		links := clippingsfeed.FeedLinks{Self: "https://feeds.example.com/feed.atom", Hub: links.Hub}
+		assert.NilError(t, clippingsfeed.WriteFeedWithLinks(&buf, feed, "atom", links))
+
+		var atom struct {
+			Links   []link   `xml:"link"`
+			Entries []string `xml:"entry>title"`
+		}
+		assert.NilError(t, xml.Unmarshal(buf.Bytes(), &atom))
+		assert.DeepEqual(t, atom.Entries, []string{"Article"})
+		assert.DeepEqual(t, atom.Links, []link{{"https://feeds.example.com/", ""}, {links.Self, "self"}, {links.Hub, "hub"}})
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		links := clippingsfeed.FeedLinks{Self: "https://feeds.example.com/feed.json", Hub: links.Hub}
+		assert.NilError(t, clippingsfeed.WriteFeedWithLinks(&buf, feed, "json", links))
+
+		var jsonFeed struct {
+			FeedURL string `json:"feed_url"`
+			Hubs    []struct {
+				Type string `json:"type"`
+				URL  string `json:"url"`
+			} `json:"hubs"`
+		}
+		assert.NilError(t, json.Unmarshal(buf.Bytes(), &jsonFeed))
+		assert.Equal(t, jsonFeed.FeedURL, links.Self)
+		assert.Equal(t, len(jsonFeed.Hubs), 1)
+		assert.Equal(t, jsonFeed.Hubs[0].URL, links.Hub)
+	})
+
+	t.Run("without links", func(t *testing.T) {
+		var plain, linked bytes.Buffer
+		assert.NilError(t, clippingsfeed.WriteFeed(&plain, feed, "rss"))
+		assert.NilError(t, clippingsfeed.WriteFeedWithLinks(&linked, feed, "rss", clippingsfeed.FeedLinks{}))
+		assert.Equal(t, linked.String(), plain.String())
+		assert.Assert(t, !strings.Contains(plain.String(), "atom:link"))
+	})
+}
@@ -0,0 +1,281 @@
+package clippingsfeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const gitDefaultPollInterval = 30 * time.Second
+
+// GitConfig configures a GitSource
+type GitConfig struct {
+	// Path of the repository, either a working tree or a bare repository
+	Path string
+
+	// Ref is the branch, tag or commit to read, HEAD when empty
+	Ref string
+
+	// PollInterval is how often Watch checks the ref for new commits, 30 seconds when zero
+	PollInterval time.Duration
+}
+
+// GitSource reads the markdown notes of a local git repository at a ref, such as a vault
+// backed up with obsidian-git. Modification times are those of the last commit changing a
+// note and creation times, reported through FileTimes, those of the first commit adding it,
+// since the times on disk are reset by every clone. Renames are followed.
+type GitSource struct {
+	repo     *git.Repository
+	ref      string
+	interval time.Duration
+
+	// mu serializes loading commits
+	mu      sync.Mutex
+	commit  plumbing.Hash
+	changes map[plumbing.Hash][]gitChange
+	fsys    atomic.Pointer[MemFS]
+}
+
+// gitChange is a change a commit made to a note compared with its first parent
+type gitChange struct {
+	from, to string
+}
+
+// NewGitSource opens the repository at config.Path. Nothing is read until Sync or Watch is
+// called.
+func NewGitSource(config GitConfig) (*GitSource, error) {
+	repo, err := git.PlainOpen(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository %s: %w", config.Path, err)
+	}
+
+	s := &GitSource{
+		repo:     repo,
+		ref:      config.Ref,
+		interval: config.PollInterval,
+		changes:  make(map[plumbing.Hash][]gitChange),
+	}
+	if s.ref == "" {
+		s.ref = "HEAD"
+	}
+	if s.interval <= 0 {
+		s.interval = gitDefaultPollInterval
+	}
+	s.fsys.Store(NewMemFS(nil))
+
+	return s, nil
+}
+
+// FS returns the notes of the last commit read
+func (s *GitSource) FS() fs.FS {
+	return s.fsys.Load()
+}
+
+// Sync reads the commit the ref points to, if it moved since the previous call
+func (s *GitSource) Sync(ctx context.Context) error {
+	_, err := s.load(ctx)
+	return err
+}
+
+// Watch checks the ref for new commits every poll interval until ctx is done and reports the
+// notes they changed. Failures, e.g. while obsidian-git is committing, are logged and retried
+// on the next tick.
+func (s *GitSource) Watch(ctx context.Context, notify func(Change)) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			changes, err := s.load(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Failed to read git repository", "ref", s.ref, "error", err)
+				}
+				continue
+			}
+			for _, change := range changes {
+				notify(change)
+			}
+		}
+	}
+}
+
+// load reads the commit the ref points to and returns how the notes changed
+func (s *GitSource) load(ctx context.Context) ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.repo.ResolveRevision(plumbing.Revision(s.ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", s.ref, err)
+	}
+	if *hash == s.commit {
+		return nil, nil
+	}
+
+	commit, err := s.repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+	created, modified, err := s.history(ctx, commit)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree of %s: %w", hash, err)
+	}
+	files := make(map[string]MemFile)
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if !IsMarkdownFile(file.Name) || !file.Mode.IsFile() {
+			return nil
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+
+		modTime, ok := modified[file.Name]
+		if !ok {
+			modTime = commit.Author.When
+		}
+		files[file.Name] = MemFile{Data: []byte(content), ModTime: modTime, Created: created[file.Name]}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	previous, _ := TakeSnapshot(ctx, s.fsys.Load(), ".", WalkOptions{})
+	fsys := NewMemFS(files)
+	current, err := TakeSnapshot(ctx, fsys, ".", WalkOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	s.fsys.Store(fsys)
+	s.commit = *hash
+	return DiffSnapshots(previous, current), nil
+}
+
+// history returns when each note was first added and last changed in the history of commit,
+// by author time. Commits are replayed oldest first so that a renamed note keeps the time it
+// was created at under its old path, and a note merged from a branch the time it was committed
+// to the branch at.
+func (s *GitSource) history(ctx context.Context, head *object.Commit) (map[string]time.Time, map[string]time.Time, error) {
+	iter, err := s.repo.Log(&git.LogOptions{From: head.Hash, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var commits []*object.Commit
+	err = iter.ForEach(func(commit *object.Commit) error {
+		commits = append(commits, commit)
+		return ctx.Err()
+	})
+	if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		// A shallow clone ends with a missing parent, its history is what was fetched
+		return nil, nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	slices.Reverse(commits)
+
+	created := make(map[string]time.Time)
+	modified := make(map[string]time.Time)
+	for _, commit := range commits {
+		changes, err := s.commitChanges(ctx, commit)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		when := commit.Author.When
+		for _, change := range changes {
+			switch {
+			case change.to == "":
+				delete(created, change.from)
+				delete(modified, change.from)
+				continue
+			case change.from == "":
+				// A merge adds the notes of the merged branch, which were replayed before it
+				if _, ok := created[change.to]; !ok {
+					created[change.to] = when
+				}
+			case change.from != change.to:
+				if t, ok := created[change.from]; ok {
+					created[change.to] = t
+				} else if _, ok := created[change.to]; !ok {
+					created[change.to] = when
+				}
+				delete(created, change.from)
+				delete(modified, change.from)
+			}
+			modified[change.to] = when
+		}
+	}
+
+	return created, modified, nil
+}
+
+// commitChanges returns the notes commit changed compared with its first parent. Commits
+// never change, so the result is cached.
+func (s *GitSource) commitChanges(ctx context.Context, commit *object.Commit) ([]gitChange, error) {
+	if changes, ok := s.changes[commit.Hash]; ok {
+		return changes, nil
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree of %s: %w", commit.Hash, err)
+	}
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		switch {
+		case errors.Is(err, plumbing.ErrObjectNotFound):
+			// The parent is missing from a shallow clone
+		case err != nil:
+			return nil, fmt.Errorf("failed to read parent of %s: %w", commit.Hash, err)
+		default:
+			if parentTree, err = parent.Tree(); err != nil {
+				return nil, fmt.Errorf("failed to read tree of %s: %w", parent.Hash, err)
+			}
+		}
+	}
+
+	diff, err := object.DiffTreeWithOptions(ctx, parentTree, tree, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s: %w", commit.Hash, err)
+	}
+
+	var changes []gitChange
+	for _, change := range diff {
+		from, to := change.From.Name, change.To.Name
+		if !IsMarkdownFile(from) && !IsMarkdownFile(to) {
+			continue
+		}
+		if !IsMarkdownFile(to) {
+			to = ""
+		}
+		if !IsMarkdownFile(from) {
+			from = ""
+		}
+		changes = append(changes, gitChange{from: from, to: to})
+	}
+
+	s.changes[commit.Hash] = changes
+	return changes, nil
+}
@@ -0,0 +1,211 @@
+package clippingsfeed_test
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+// gitVault is a repository whose commits are authored at fixed times
+type gitVault struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+	tree *git.Worktree
+}
+
+func newGitVault(t *testing.T) *gitVault {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	assert.NilError(t, err)
+	tree, err := repo.Worktree()
+	assert.NilError(t, err)
+	return &gitVault{t: t, dir: dir, repo: repo, tree: tree}
+}
+
+func (v *gitVault) write(name, content string) {
+	v.t.Helper()
+	path := filepath.Join(v.dir, filepath.FromSlash(name))
+	assert.NilError(v.t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NilError(v.t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func (v *gitVault) rename(from, to string) {
+	v.t.Helper()
+	_, err := v.tree.Move(from, to)
+	assert.NilError(v.t, err)
+}
+
+func (v *gitVault) commit(when time.Time) {
+	v.t.Helper()
+	assert.NilError(v.t, v.tree.AddWithOptions(&git.AddOptions{All: true}))
+	signature := &object.Signature{Name: "vault", Email: "vault@example.com", When: when}
+	_, err := v.tree.Commit("vault backup", &git.CommitOptions{Author: signature, Committer: signature, AllowEmptyCommits: true})
+	assert.NilError(v.t, err)
+}
+
+func gitFileTimes(t *testing.T, fsys fs.FS, name string) (time.Time, time.Time) {
+	t.Helper()
+	info, err := fs.Stat(fsys, name)
+	assert.NilError(t, err)
+	times, ok := info.Sys().(clippingsfeed.FileTimes)
+	assert.Assert(t, ok, "%s has no creation time", name)
+	return times.Created, info.ModTime()
+}
+
+func TestGitSource(t *testing.T) {
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	third := second.Add(24 * time.Hour)
+
+	vault := newGitVault(t)
+	vault.write("Clippings/article.md", "---\ntitle: Article\n---\nbody of the clipped article\n")
+	vault.write("Clippings/kept.md", "kept as is")
+	vault.write("image.png", "not a note")
+	vault.commit(first)
+
+	vault.rename("Clippings/article.md", "Archive/article.md")
+	vault.write("Clippings/kept.md", "kept, then edited")
+	vault.commit(second)
+
+	source, err := clippingsfeed.NewGitSource(clippingsfeed.GitConfig{Path: vault.dir})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Sync(t.Context()))
+
+	data, err := fs.ReadFile(source.FS(), "Archive/article.md")
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "---\ntitle: Article\n---\nbody of the clipped article\n")
+	_, err = fs.Stat(source.FS(), "image.png")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// A renamed note keeps the time it was first committed at
+	created, modified := gitFileTimes(t, source.FS(), "Archive/article.md")
+	assert.Assert(t, created.Equal(first), "created %v", created)
+	assert.Assert(t, modified.Equal(second), "modified %v", modified)
+
+	created, modified = gitFileTimes(t, source.FS(), "Clippings/kept.md")
+	assert.Assert(t, created.Equal(first), "created %v", created)
+	assert.Assert(t, modified.Equal(second), "modified %v", modified)
+
+	// Notes without a created property are dated by their first commit
+	metadata, _, err := clippingsfeed.ScanVault(t.Context(), clippingsfeed.CreateParser(), source.FS(), clippingsfeed.WalkOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(metadata), 2)
+	for _, m := range metadata {
+		assert.Assert(t, m.Created.Equal(first), "%s created %v", m.Title, m.Created)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	watcher, err := clippingsfeed.NewGitSource(clippingsfeed.GitConfig{Path: vault.dir, PollInterval: 10 * time.Millisecond})
+	assert.NilError(t, err)
+	assert.NilError(t, watcher.Sync(ctx))
+
+	recorder := &changeRecorder{}
+	done := make(chan error, 1)
+	go func() {
+		done <- watcher.Watch(ctx, recorder.notify)
+	}()
+
+	vault.write("Clippings/new.md", "new")
+	vault.commit(third)
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Clippings/new.md", Op: clippingsfeed.ChangeCreate}))
+
+	created, _ = gitFileTimes(t, watcher.FS(), "Clippings/new.md")
+	assert.Assert(t, created.Equal(third), "created %v", created)
+
+	cancel()
+	assert.NilError(t, <-done)
+}
+
+func TestGitSourceMerge(t *testing.T) {
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	second := first.Add(24 * time.Hour)
+	third := second.Add(24 * time.Hour)
+	merged := third.Add(24 * time.Hour)
+
+	vault := newGitVault(t)
+	vault.write("desk.md", "clipped at the desk")
+	vault.write("draft.md", "renamed on the phone")
+	vault.commit(first)
+	head, err := vault.repo.Head()
+	assert.NilError(t, err)
+
+	// A branch clips a note and renames another, then is merged while the main branch moved on
+	assert.NilError(t, vault.tree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("phone"), Create: true}))
+	vault.write("phone.md", "clipped on the phone")
+	vault.rename("draft.md", "renamed.md")
+	vault.commit(second)
+	phone, err := vault.repo.Head()
+	assert.NilError(t, err)
+
+	assert.NilError(t, vault.tree.Checkout(&git.CheckoutOptions{Branch: head.Name()}))
+	vault.write("later.md", "clipped later")
+	vault.commit(third)
+	trunk, err := vault.repo.Head()
+	assert.NilError(t, err)
+
+	vault.write("phone.md", "clipped on the phone")
+	vault.rename("draft.md", "renamed.md")
+	signature := &object.Signature{Name: "vault", Email: "vault@example.com", When: merged}
+	assert.NilError(t, vault.tree.AddWithOptions(&git.AddOptions{All: true}))
+	_, err = vault.tree.Commit("merge phone", &git.CommitOptions{
+		Author: signature, Committer: signature, Parents: []plumbing.Hash{trunk.Hash(), phone.Hash()},
+	})
+	assert.NilError(t, err)
+
+	source, err := clippingsfeed.NewGitSource(clippingsfeed.GitConfig{Path: vault.dir})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Sync(t.Context()))
+
+	for name, expected := range map[string]time.Time{"desk.md": first, "phone.md": second, "renamed.md": first, "later.md": third} {
+		created, _ := gitFileTimes(t, source.FS(), name)
+		assert.Assert(t, created.Equal(expected), "%s created %v", name, created)
+	}
+}
+
+func TestGitSourceRef(t *testing.T) {
+	first := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	vault := newGitVault(t)
+	vault.write("tagged.md", "tagged")
+	vault.commit(first)
+	head, err := vault.repo.Head()
+	assert.NilError(t, err)
+	_, err = vault.repo.CreateTag("v1", head.Hash(), &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "vault", Email: "vault@example.com", When: first},
+		Message: "v1",
+	})
+	assert.NilError(t, err)
+
+	vault.write("later.md", "later")
+	vault.commit(first.Add(time.Hour))
+
+	source, err := clippingsfeed.NewGitSource(clippingsfeed.GitConfig{Path: vault.dir, Ref: "v1"})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Sync(t.Context()))
+
+	_, err = fs.Stat(source.FS(), "tagged.md")
+	assert.NilError(t, err)
+	_, err = fs.Stat(source.FS(), "later.md")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	missing, err := clippingsfeed.NewGitSource(clippingsfeed.GitConfig{Path: vault.dir, Ref: "missing"})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, missing.Sync(t.Context()), "failed to resolve missing")
+
+	_, err = clippingsfeed.NewGitSource(clippingsfeed.GitConfig{Path: t.TempDir()})
+	assert.ErrorContains(t, err, "failed to open git repository")
+}
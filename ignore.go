@@ -0,0 +1,152 @@
+package clippingsfeed
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultIgnorePatterns skip dot-directories such as .obsidian, .git and the Obsidian trash
+var DefaultIgnorePatterns = []string{".*/", ".trash/"}
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// IgnoreMatcher decides which vault paths to skip using gitignore-style patterns:
+//
+//   - a pattern without a slash matches a name at any depth, e.g. "*.excalidraw.md"
+//   - a pattern with a slash is relative to the vault root, e.g. "/Templates" or "Daily/2023"
+//   - a trailing slash only matches directories, e.g. "Templates/"
+//   - "*" and "?" do not cross directories, "**" does
+//   - a leading "!" re-includes paths excluded by an earlier pattern
+//   - blank lines and lines starting with "#" are skipped
+//
+// As in git, the last matching pattern wins and the contents of an ignored directory are
+// ignored with it.
+type IgnoreMatcher struct {
+	rules []ignoreRule
+}
+
+func NewIgnoreMatcher(patterns []string) *IgnoreMatcher {
+	m := &IgnoreMatcher{}
+	for _, pattern := range patterns {
+		if rule, ok := parseIgnorePattern(pattern); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+	return m
+}
+
+// Match reports whether the slash-separated path relative to the vault root is ignored
+func (m *IgnoreMatcher) Match(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	relPath = strings.Trim(relPath, "/")
+
+	// Nothing below an ignored directory can be re-included
+	for i := range len(relPath) {
+		if relPath[i] == '/' && m.match(relPath[:i], true) {
+			return true
+		}
+	}
+	return m.match(relPath, isDir)
+}
+
+func (m *IgnoreMatcher) match(relPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(relPath) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func parseIgnorePattern(pattern string) (ignoreRule, bool) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimLeft(pattern, "/")
+	if pattern == "" {
+		return ignoreRule{}, false
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	expr.WriteString(globToRegexp(pattern))
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		// Fall back to a literal match for malformed character classes
+		re = regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+	}
+	rule.pattern = re
+
+	return rule, true
+}
+
+func globToRegexp(glob string) string {
+	var expr strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return expr.String()
+}
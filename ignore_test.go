@@ -0,0 +1,165 @@
+package clippingsfeed_test
+
+import (
+	"testing"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	for name, tt := range map[string]struct {
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		"default dot directory": {
+			patterns: clippingsfeed.DefaultIgnorePatterns,
+			path:     ".obsidian",
+			isDir:    true,
+			expected: true,
+		},
+		"default nested dot directory": {
+			patterns: clippingsfeed.DefaultIgnorePatterns,
+			path:     "Clippings/.git",
+			isDir:    true,
+			expected: true,
+		},
+		"default trash": {
+			patterns: clippingsfeed.DefaultIgnorePatterns,
+			path:     ".trash",
+			isDir:    true,
+			expected: true,
+		},
+		"default dot file is kept": {
+			patterns: clippingsfeed.DefaultIgnorePatterns,
+			path:     ".note.md",
+			expected: false,
+		},
+		"default regular note": {
+			patterns: clippingsfeed.DefaultIgnorePatterns,
+			path:     "Clippings/note.md",
+			expected: false,
+		},
+		"contents of ignored directory": {
+			patterns: clippingsfeed.DefaultIgnorePatterns,
+			path:     ".trash/Clippings/note.md",
+			expected: true,
+		},
+		"re-including a file below an ignored directory": {
+			patterns: []string{"Archive/", "!Archive/keep.md"},
+			path:     "Archive/keep.md",
+			expected: true,
+		},
+		"basename at any depth": {
+			patterns: []string{"*.excalidraw.md"},
+			path:     "Drawings/2024/sketch.excalidraw.md",
+			expected: true,
+		},
+		"directory only pattern skips files": {
+			patterns: []string{"Templates/"},
+			path:     "Templates",
+			expected: false,
+		},
+		"directory only pattern": {
+			patterns: []string{"Templates/"},
+			path:     "Notes/Templates",
+			isDir:    true,
+			expected: true,
+		},
+		"anchored pattern": {
+			patterns: []string{"/Templates"},
+			path:     "Notes/Templates",
+			isDir:    true,
+			expected: false,
+		},
+		"anchored pattern at root": {
+			patterns: []string{"/Templates"},
+			path:     "Templates",
+			isDir:    true,
+			expected: true,
+		},
+		"pattern with slash is anchored": {
+			patterns: []string{"Daily/2023"},
+			path:     "Archive/Daily/2023",
+			isDir:    true,
+			expected: false,
+		},
+		"star does not cross directories": {
+			patterns: []string{"Daily/*.md"},
+			path:     "Daily/2023/note.md",
+			expected: false,
+		},
+		"double star crosses directories": {
+			patterns: []string{"Daily/**/*.md"},
+			path:     "Daily/2023/01/note.md",
+			expected: true,
+		},
+		"leading double star": {
+			patterns: []string{"**/drafts"},
+			path:     "a/b/drafts",
+			isDir:    true,
+			expected: true,
+		},
+		"trailing double star": {
+			patterns: []string{"Archive/**"},
+			path:     "Archive/old/note.md",
+			expected: true,
+		},
+		"question mark": {
+			patterns: []string{"note?.md"},
+			path:     "note1.md",
+			expected: true,
+		},
+		"character class": {
+			patterns: []string{"note[0-9].md"},
+			path:     "notea.md",
+			expected: false,
+		},
+		"negated character class": {
+			patterns: []string{"note[!0-9].md"},
+			path:     "notea.md",
+			expected: true,
+		},
+		"negation re-includes": {
+			patterns: append([]string{"!.clippings/"}, clippingsfeed.DefaultIgnorePatterns...),
+			path:     ".clippings",
+			isDir:    true,
+			expected: true,
+		},
+		"last match wins": {
+			patterns: append(clippingsfeed.DefaultIgnorePatterns, "!.clippings/"),
+			path:     ".clippings",
+			isDir:    true,
+			expected: false,
+		},
+		"comments and blanks": {
+			patterns: []string{"# Templates/", "  ", ""},
+			path:     "Templates",
+			isDir:    true,
+			expected: false,
+		},
+		"escaped special character": {
+			patterns: []string{`\#inbox`},
+			path:     "#inbox",
+			isDir:    true,
+			expected: true,
+		},
+		"literal dot": {
+			patterns: []string{"a.md"},
+			path:     "abmd",
+			expected: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			matcher := clippingsfeed.NewIgnoreMatcher(tt.patterns)
+			assert.Equal(t, tt.expected, matcher.Match(tt.path, tt.isDir))
+		})
+	}
+}
+
+func TestIgnoreMatcherNil(t *testing.T) {
+	var matcher *clippingsfeed.IgnoreMatcher
+	assert.Assert(t, !matcher.Match(".obsidian", true))
+}
@@ -0,0 +1,349 @@
+package clippingsfeed_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+// fakeCouchDB serves the parts of the CouchDB API used by LiveSyncSource for a database
+// named "vault"
+type fakeCouchDB struct {
+	mu      sync.Mutex
+	seq     int
+	docs    map[string]map[string]any
+	seqs    map[string]int
+	changed chan struct{}
+}
+
+func newFakeCouchDB(t *testing.T) (*fakeCouchDB, *httptest.Server) {
+	t.Helper()
+
+	db := &fakeCouchDB{
+		docs:    make(map[string]map[string]any),
+		seqs:    make(map[string]int),
+		changed: make(chan struct{}),
+	}
+	server := httptest.NewServer(db)
+	t.Cleanup(server.Close)
+	return db, server
+}
+
+// put stores doc, bumping its revision like CouchDB would
+func (db *fakeCouchDB) put(doc map[string]any) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	id := doc["_id"].(string)
+	generation := 1
+	if previous, ok := db.docs[id]; ok {
+		generation, _ = strconv.Atoi(strings.Split(previous["_rev"].(string), "-")[0])
+		generation++
+	}
+	doc["_rev"] = fmt.Sprintf("%d-%x", generation, generation)
+
+	db.seq++
+	db.docs[id] = doc
+	db.seqs[id] = db.seq
+	close(db.changed)
+	db.changed = make(chan struct{})
+}
+
+func (db *fakeCouchDB) remove(id string) {
+	db.put(map[string]any{"_id": id, "_deleted": true})
+}
+
+func (db *fakeCouchDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/vault/_changes":
+		db.serveChanges(w, r)
+	case "/vault/_all_docs":
+		db.serveAllDocs(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (db *fakeCouchDB) serveChanges(w http.ResponseWriter, r *http.Request) {
+	// Sequences are opaque strings like CouchDB 2 and later
+	since, _, _ := strings.Cut(r.URL.Query().Get("since"), "-")
+	sinceSeq, _ := strconv.Atoi(since)
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+
+	for {
+		db.mu.Lock()
+		var results []map[string]any
+		for id, seq := range db.seqs {
+			if seq > sinceSeq {
+				deleted, _ := db.docs[id]["_deleted"].(bool)
+				results = append(results, map[string]any{"seq": strconv.Itoa(seq) + "-opaque", "id": id, "deleted": deleted, "doc": db.docs[id]})
+			}
+		}
+		lastSeq, changed := db.seq, db.changed
+		db.mu.Unlock()
+
+		if len(results) > 0 || r.URL.Query().Get("feed") != "longpoll" {
+			writeJSON(w, map[string]any{"results": results, "last_seq": strconv.Itoa(lastSeq) + "-opaque"})
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(timeout) * time.Millisecond):
+			writeJSON(w, map[string]any{"results": results, "last_seq": strconv.Itoa(lastSeq) + "-opaque"})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (db *fakeCouchDB) serveAllDocs(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	rows := []map[string]any{}
+	for _, key := range body.Keys {
+		if doc, ok := db.docs[key]; ok {
+			rows = append(rows, map[string]any{"key": key, "id": key, "doc": doc})
+		} else {
+			rows = append(rows, map[string]any{"key": key, "error": "not_found"})
+		}
+	}
+	writeJSON(w, map[string]any{"rows": rows})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// liveSyncNote returns a note document split into the given chunks, the first of which is
+// kept in eden
+func liveSyncNote(id, path string, mtime time.Time, chunkIDs ...string) map[string]any {
+	doc := map[string]any{
+		"_id":      id,
+		"path":     path,
+		"type":     "plain",
+		"children": chunkIDs,
+		"ctime":    mtime.UnixMilli(),
+		"mtime":    mtime.UnixMilli(),
+		"eden":     map[string]any{},
+	}
+	return doc
+}
+
+func liveSyncChunk(id, data string) map[string]any {
+	return map[string]any{"_id": id, "type": "leaf", "data": data}
+}
+
+// encryptLiveSync encrypts data in the format LiveSync uses for end-to-end encryption
+func encryptLiveSync(t *testing.T, passphrase, data string, legacy bool) string {
+	t.Helper()
+
+	salt := make([]byte, 16)
+	iv := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	_, _ = rand.Read(iv)
+
+	digest := sha256.Sum256([]byte(passphrase))
+	key, err := pbkdf2.Key(sha256.New, string(digest[:]), salt, 100000, 32)
+	assert.NilError(t, err)
+	block, err := aes.NewCipher(key)
+	assert.NilError(t, err)
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	assert.NilError(t, err)
+
+	if legacy {
+		plain, err := json.Marshal(data)
+		assert.NilError(t, err)
+		sealed := base64.StdEncoding.EncodeToString(aead.Seal(nil, iv, plain, nil))
+		encoded, err := json.Marshal([]string{sealed, hex.EncodeToString(iv), hex.EncodeToString(salt)})
+		assert.NilError(t, err)
+		return string(encoded)
+	}
+
+	sealed := aead.Seal(nil, iv, []byte(data), nil)
+	return "%" + hex.EncodeToString(iv) + hex.EncodeToString(salt) + base64.StdEncoding.EncodeToString(sealed)
+}
+
+func readFS(t *testing.T, fsys fs.FS) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		files[name] = string(data)
+		return err
+	})
+	assert.NilError(t, err)
+	return files
+}
+
+func TestLiveSyncSource(t *testing.T) {
+	db, server := newFakeCouchDB(t)
+	mtime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	article := liveSyncNote("clippings/article.md", "Clippings/Article.md", mtime, "h:eden", "h:1", "h:2")
+	article["eden"] = map[string]any{"h:eden": map[string]any{"data": "---\ntitle: Article\n", "epoch": 1}}
+	db.put(article)
+	db.put(liveSyncChunk("h:1", "source: https://example.com/article\n"))
+	db.put(liveSyncChunk("h:2", "---\nBody\n"))
+
+	legacy := map[string]any{"_id": "legacy.md", "path": "legacy.md", "type": "notes", "data": "legacy", "mtime": mtime.UnixMilli()}
+	db.put(legacy)
+
+	image := liveSyncNote("image.png", "image.png", mtime, "h:3")
+	image["type"] = "newnote"
+	db.put(image)
+
+	db.put(liveSyncNote("i:.obsidian/app.json", "i:.obsidian/app.json", mtime, "h:1"))
+
+	trashed := liveSyncNote("trashed.md", "trashed.md", mtime, "h:1")
+	trashed["deleted"] = true
+	db.put(trashed)
+
+	source, err := clippingsfeed.NewLiveSyncSource(clippingsfeed.LiveSyncConfig{
+		URL:         strings.Replace(server.URL, "http://", "http://admin:secret@", 1) + "/vault/",
+		PollTimeout: 50 * time.Millisecond,
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Sync(t.Context()))
+
+	assert.DeepEqual(t, readFS(t, source.FS()), map[string]string{
+		"Clippings/Article.md": "---\ntitle: Article\nsource: https://example.com/article\n---\nBody\n",
+		"legacy.md":            "legacy",
+	})
+	info, err := fs.Stat(source.FS(), "Clippings/Article.md")
+	assert.NilError(t, err)
+	assert.Assert(t, info.ModTime().Equal(mtime))
+
+	metadata, _, err := clippingsfeed.ScanVault(t.Context(), clippingsfeed.CreateParser(), source.FS(), clippingsfeed.WalkOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(metadata), 2)
+
+	// Follow the changes feed
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	recorder := &changeRecorder{}
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Watch(ctx, recorder.notify)
+	}()
+
+	article["eden"] = map[string]any{"h:eden": map[string]any{"data": "---\ntitle: Updated\n", "epoch": 2}}
+	db.put(article)
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Clippings/Article.md", Op: clippingsfeed.ChangeWrite}))
+
+	db.remove("legacy.md")
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "legacy.md", Op: clippingsfeed.ChangeRemove}))
+
+	// A note replicated before its chunks appears once they arrive
+	db.put(liveSyncNote("late.md", "late.md", mtime, "h:late"))
+	time.Sleep(100 * time.Millisecond)
+	_, err = fs.Stat(source.FS(), "late.md")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	db.put(liveSyncChunk("h:late", "late"))
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "late.md", Op: clippingsfeed.ChangeCreate}))
+
+	cancel()
+	assert.NilError(t, <-done)
+
+	assert.DeepEqual(t, readFS(t, source.FS()), map[string]string{
+		"Clippings/Article.md": "---\ntitle: Updated\nsource: https://example.com/article\n---\nBody\n",
+		"late.md":              "late",
+	})
+}
+
+func TestLiveSyncSourceEncrypted(t *testing.T) {
+	db, server := newFakeCouchDB(t)
+	mtime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	passphrase := "correct horse battery staple"
+
+	note := liveSyncNote("f:obfuscated", encryptLiveSync(t, passphrase, "Secret/Note.md", false), mtime, "h:+eden", "h:+1", "h:+2")
+	note["eden"] = map[string]any{"h:+eden": map[string]any{"data": encryptLiveSync(t, passphrase, "first ", false)}}
+	db.put(note)
+	chunk := liveSyncChunk("h:+1", encryptLiveSync(t, passphrase, "second ", false))
+	chunk["e_"] = true
+	db.put(chunk)
+	db.put(liveSyncChunk("h:+2", encryptLiveSync(t, passphrase, "third", true)))
+	db.put(liveSyncNote("[draft] plain.md", "[draft] plain.md", mtime, "h:plain"))
+	db.put(liveSyncChunk("h:plain", "%% not encrypted %%"))
+
+	url := strings.Replace(server.URL, "http://", "http://admin:secret@", 1) + "/vault"
+
+	source, err := clippingsfeed.NewLiveSyncSource(clippingsfeed.LiveSyncConfig{URL: url, Passphrase: passphrase})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Sync(t.Context()))
+	assert.DeepEqual(t, readFS(t, source.FS()), map[string]string{
+		"Secret/Note.md":   "first second third",
+		"[draft] plain.md": "%% not encrypted %%",
+	})
+
+	// Notes that cannot be decrypted are left out without holding back the others
+	readable := map[string]string{"[draft] plain.md": "%% not encrypted %%"}
+	for i, passphrase := range []string{"wrong", ""} {
+		t.Run(fmt.Sprintf("passphrase %q", passphrase), func(t *testing.T) {
+			source, err := clippingsfeed.NewLiveSyncSource(clippingsfeed.LiveSyncConfig{URL: url, Passphrase: passphrase})
+			assert.NilError(t, err)
+			assert.NilError(t, source.Sync(t.Context()))
+			assert.DeepEqual(t, readFS(t, source.FS()), readable)
+
+			db.put(liveSyncNote(fmt.Sprintf("encrypted-%d.md", i), "encrypted.md", mtime, "h:+1"))
+			later := fmt.Sprintf("later-%d.md", i)
+			db.put(liveSyncNote(later, later, mtime, "h:plain"))
+			readable[later] = "%% not encrypted %%"
+			assert.NilError(t, source.Sync(t.Context()))
+			assert.DeepEqual(t, readFS(t, source.FS()), readable)
+		})
+	}
+}
+
+func TestLiveSyncSourceUnauthorized(t *testing.T) {
+	_, server := newFakeCouchDB(t)
+
+	source, err := clippingsfeed.NewLiveSyncSource(clippingsfeed.LiveSyncConfig{URL: server.URL + "/vault"})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, source.Sync(t.Context()), "401")
+}
+
+func TestNewLiveSyncSourceInvalidURL(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/vault", "http://example.com", "http://example.com/", "://"} {
+		_, err := clippingsfeed.NewLiveSyncSource(clippingsfeed.LiveSyncConfig{URL: u})
+		assert.Assert(t, err != nil, "expected an error for %s", u)
+	}
+}
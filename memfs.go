@@ -0,0 +1,181 @@
+package clippingsfeed
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MemFile is a file of a MemFS. Created, when set, is reported through FileTimes.
+type MemFile struct {
+	Data    []byte
+	ModTime time.Time
+	Created time.Time
+}
+
+// MemFS is a read-only in-memory filesystem for sources that fetch their notes, such as
+// remote databases and archives. Directories are implied by the paths of the files.
+type MemFS struct {
+	files map[string]MemFile
+	dirs  map[string][]fs.DirEntry
+}
+
+// NewMemFS returns a filesystem holding files, keyed by slash-separated paths. Paths that
+// are not valid fs paths are left out.
+func NewMemFS(files map[string]MemFile) *MemFS {
+	m := &MemFS{
+		files: make(map[string]MemFile, len(files)),
+		dirs:  map[string][]fs.DirEntry{".": nil},
+	}
+
+	for name, file := range files {
+		if fs.ValidPath(name) && name != "." {
+			m.files[name] = file
+		}
+	}
+
+	// Collect the implied directories; a file cannot also be a directory
+	for name := range m.files {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if _, ok := m.dirs[dir]; ok {
+				break
+			}
+			m.dirs[dir] = nil
+		}
+	}
+	for dir := range m.dirs {
+		delete(m.files, dir)
+	}
+
+	for name, file := range m.files {
+		info := memFileInfo{name: path.Base(name), size: int64(len(file.Data)), modTime: file.ModTime, created: file.Created}
+		m.dirs[path.Dir(name)] = append(m.dirs[path.Dir(name)], fs.FileInfoToDirEntry(info))
+	}
+	for dir := range m.dirs {
+		if dir != "." {
+			info := memFileInfo{name: path.Base(dir), dir: true}
+			m.dirs[path.Dir(dir)] = append(m.dirs[path.Dir(dir)], fs.FileInfoToDirEntry(info))
+		}
+	}
+	for _, entries := range m.dirs {
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+
+	return m
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if file, ok := m.files[name]; ok {
+		info := memFileInfo{name: path.Base(name), size: int64(len(file.Data)), modTime: file.ModTime, created: file.Created}
+		return &memFile{info: info, Reader: bytes.NewReader(file.Data)}, nil
+	}
+	if entries, ok := m.dirs[name]; ok {
+		return &memDir{info: memFileInfo{name: path.Base(name), dir: true}, entries: entries}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	if file, ok := m.files[name]; ok {
+		return bytes.Clone(file.Data), nil
+	}
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, ok := m.dirs[name]; ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, ok := m.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return slices.Clone(entries), nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.Stat()
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	created time.Time
+	dir     bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.dir }
+
+func (i memFileInfo) Sys() any {
+	if i.created.IsZero() {
+		return nil
+	}
+	return FileTimes{Created: i.created}
+}
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type memFile struct {
+	info memFileInfo
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memDir struct {
+	info    memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return slices.Clone(remaining), nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(remaining))
+	d.offset += n
+	return slices.Clone(remaining[:n]), nil
+}
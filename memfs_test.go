@@ -0,0 +1,50 @@
+package clippingsfeed_test
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func TestMemFS(t *testing.T) {
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fsys := clippingsfeed.NewMemFS(map[string]clippingsfeed.MemFile{
+		"note.md":             {Data: []byte("note"), ModTime: modTime},
+		"Clippings/a.md":      {Data: []byte("a"), ModTime: modTime},
+		"Clippings/deep/b.md": {Data: []byte("b"), ModTime: modTime},
+		"empty.md":            {},
+	})
+
+	assert.NilError(t, fstest.TestFS(fsys, "note.md", "Clippings/a.md", "Clippings/deep/b.md", "empty.md"))
+
+	data, err := fs.ReadFile(fsys, "Clippings/deep/b.md")
+	assert.NilError(t, err)
+	assert.Equal(t, string(data), "b")
+
+	info, err := fs.Stat(fsys, "note.md")
+	assert.NilError(t, err)
+	assert.Equal(t, info.Size(), int64(4))
+	assert.Assert(t, info.ModTime().Equal(modTime))
+
+	_, err = fs.Stat(fsys, "missing.md")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMemFSInvalidPaths(t *testing.T) {
+	fsys := clippingsfeed.NewMemFS(map[string]clippingsfeed.MemFile{
+		"/absolute.md":  {Data: []byte("absolute")},
+		"../outside.md": {Data: []byte("outside")},
+		"a":             {Data: []byte("file shadowed by a directory")},
+		"a/b.md":        {Data: []byte("b")},
+	})
+
+	entries, err := fs.ReadDir(fsys, ".")
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Name(), "a")
+	assert.Assert(t, entries[0].IsDir())
+}
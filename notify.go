@@ -0,0 +1,350 @@
+package clippingsfeed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// NotifySource is a vault directory on disk whose changes are reported by fsnotify. Every
+// directory of the vault that is not ignored is watched, including the ones created in or
+// moved into it later.
+type NotifySource struct {
+	dir      string
+	fsys     fs.FS
+	interval time.Duration
+	opts     WalkOptions
+
+	// addWatch registers a directory with a watcher
+	addWatch func(watcher *fsnotify.Watcher, path string) error
+}
+
+// NewNotifySource returns a Source for the vault directory dir. The directories watched are
+// the ones WalkVault visits with opts. Directories that cannot be watched, e.g. because the
+// inotify watch limit is reached, are polled every interval instead until watching them
+// succeeds; they are not polled when interval is not positive.
+func NewNotifySource(dir string, interval time.Duration, opts WalkOptions) *NotifySource {
+	return &NotifySource{
+		dir:      dir,
+		fsys:     os.DirFS(dir),
+		interval: interval,
+		opts:     opts,
+		addWatch: (*fsnotify.Watcher).Add,
+	}
+}
+
+func (s *NotifySource) FS() fs.FS {
+	return s.fsys
+}
+
+// Watch reports the changes made to the vault from when Watch is called
+func (s *NotifySource) Watch(ctx context.Context, notify func(Change)) error {
+	previous, err := TakeSnapshot(ctx, s.fsys, ".", s.opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to scan vault: %w", err)
+	}
+	return s.WatchSince(ctx, previous, notify)
+}
+
+// WatchSince is Watch starting from a known state of the vault, typically the snapshot
+// returned by ScanVault: once the vault is watched, the changes made since previous are
+// reported too.
+//
+// Besides the changes to notes, a directory removed from or renamed out of the vault is
+// reported with its own path, as it takes the notes below it along. When the watcher loses
+// events, the vault is watched again from scratch and reported as a ChangeRescan of ".".
+func (s *NotifySource) WatchSince(ctx context.Context, previous Snapshot, notify func(Change)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	w := &notifyWatch{
+		NotifySource: s,
+		ctx:          ctx,
+		watcher:      watcher,
+		notify:       notify,
+		watched:      make(map[string]struct{}),
+		unwatched:    make(map[string]Snapshot),
+	}
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			slog.Error("Error closing file watcher", "error", err)
+		}
+	}()
+
+	_, failed, err := w.addWatches(".")
+	if err != nil {
+		return fmt.Errorf("failed to add watches: %w", err)
+	}
+	if failed > 0 {
+		slog.Warn("Some directories of the vault are not watched, polling them instead",
+			"failedDirectories", failed, "pollInterval", s.interval)
+	}
+
+	current, err := TakeSnapshot(ctx, s.fsys, ".", s.opts)
+	switch {
+	case ctx.Err() != nil:
+		return nil
+	case err != nil:
+		slog.Error("Failed to scan vault, rescanning", "error", err)
+		notify(Change{Path: ".", Op: ChangeRescan})
+	default:
+		for _, change := range DiffSnapshots(previous, current) {
+			notify(change)
+		}
+	}
+
+	var ticker <-chan time.Time
+	if s.interval > 0 {
+		t := time.NewTicker(s.interval)
+		defer t.Stop()
+		ticker = t.C
+	}
+
+	for {
+		// The unwatched directories are only polled while there are any
+		var poll <-chan time.Time
+		if len(w.unwatched) > 0 {
+			poll = ticker
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-poll:
+			w.poll()
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(event)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.recover(err)
+		}
+	}
+}
+
+// notifyWatch is the state of one call to WatchSince
+type notifyWatch struct {
+	*NotifySource
+	ctx     context.Context
+	watcher *fsnotify.Watcher
+	notify  func(Change)
+
+	// watched is the set of directories registered with watcher, by slash-separated path
+	// relative to the vault root
+	watched map[string]struct{}
+
+	// unwatched holds the directories that could not be watched, with the state of the notes
+	// below them when they were last polled
+	unwatched map[string]Snapshot
+}
+
+// path returns the OS path of the slash-separated path name relative to the vault root
+func (w *notifyWatch) path(name string) string {
+	return filepath.Join(w.dir, filepath.FromSlash(name))
+}
+
+// rel returns the slash-separated path of the OS path path relative to the vault root
+func (w *notifyWatch) rel(path string) (string, bool) {
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// addWatches watches dir and every directory below it, and returns the notes found below it.
+// Directories that cannot be watched are logged, counted and polled from then on. A symlinked
+// dir other than the root is only watched when following symlinks.
+func (w *notifyWatch) addWatches(dir string) ([]string, int, error) {
+	if dir != "." && !w.opts.FollowSymlinks {
+		if info, err := os.Lstat(w.path(dir)); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return nil, 0, nil
+		}
+	}
+
+	var notes, failed []string
+	err := WalkVault(w.fsys, dir, w.opts, func(name string, d fs.DirEntry) error {
+		if !d.IsDir() {
+			if IsMarkdownFile(name) {
+				notes = append(notes, name)
+			}
+			return nil
+		}
+
+		if err := w.addWatch(w.watcher, w.path(name)); err != nil {
+			slog.Warn("Failed to watch directory", "directory", name, "error", err)
+			failed = append(failed, name)
+			return nil
+		}
+		w.watched[name] = struct{}{}
+		delete(w.unwatched, name)
+		slog.Debug("Watching directory", "directory", name)
+		return nil
+	})
+
+	for _, name := range failed {
+		snapshot, err := TakeSnapshot(w.ctx, w.fsys, name, w.opts)
+		if err != nil {
+			// Every note below it is reported once it can be read
+			snapshot = nil
+		}
+		w.unwatched[name] = snapshot
+	}
+	return notes, len(failed), err
+}
+
+// poll reports the changes made below the directories that could not be watched since they
+// were last polled, and tries to watch them again
+func (w *notifyWatch) poll() {
+	for dir, previous := range w.unwatched {
+		current, err := TakeSnapshot(w.ctx, w.fsys, dir, w.opts)
+		missing := errors.Is(err, fs.ErrNotExist)
+		switch {
+		case missing:
+			current = nil
+		case err != nil:
+			if w.ctx.Err() == nil {
+				slog.Error("Failed to poll directory", "directory", dir, "error", err)
+			}
+			continue
+		}
+
+		for _, change := range DiffSnapshots(previous, current) {
+			w.notify(change)
+		}
+		delete(w.unwatched, dir)
+		if missing {
+			continue
+		}
+
+		if _, failed, err := w.addWatches(dir); err != nil {
+			slog.Warn("Failed to watch directory", "directory", dir, "error", err)
+			w.unwatched[dir] = current
+		} else if _, ok := w.unwatched[dir]; ok {
+			// Still not watched, polled again from what was just reported
+			w.unwatched[dir] = current
+		} else {
+			slog.Info("Watching directory that could not be watched before", "directory", dir, "failedDirectories", failed)
+		}
+	}
+}
+
+// removeWatches stops watching dir and every watched directory below it
+func (w *notifyWatch) removeWatches(dir string) {
+	for name := range w.unwatched {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			delete(w.unwatched, name)
+		}
+	}
+	for name := range w.watched {
+		if name != dir && !strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+
+		// The kernel drops the watch of a deleted directory by itself
+		if err := w.watcher.Remove(w.path(name)); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+			slog.Debug("Failed to remove watch", "directory", name, "error", err)
+		}
+		delete(w.watched, name)
+		slog.Debug("Stopped watching directory", "directory", name)
+	}
+}
+
+// handleEvent reports the change event makes to the notes, and keeps the watched directory
+// set in line with the vault. fsnotify reports a directory moved into the vault as a single
+// event, so its subtree is watched and its notes are reported here.
+func (w *notifyWatch) handleEvent(event fsnotify.Event) {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+		return
+	}
+	name, ok := w.rel(event.Name)
+	if !ok {
+		return
+	}
+
+	if IsMarkdownFile(name) && !w.opts.Ignored(name, false) {
+		w.notify(Change{Path: name, Op: changeOp(event.Op)})
+	}
+
+	switch {
+	case event.Has(fsnotify.Create):
+		stat, err := os.Stat(event.Name)
+		if err != nil || !stat.IsDir() {
+			return
+		}
+
+		notes, failed, err := w.addWatches(name)
+		if err != nil {
+			slog.Warn("Failed to watch new directory", "directory", name, "error", err)
+			return
+		}
+		slog.Info("Added watches for new directory", "directory", name, "failedDirectories", failed)
+		for _, note := range notes {
+			w.notify(Change{Path: note, Op: ChangeCreate})
+		}
+
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		if _, ok := w.watched[name]; !ok {
+			return
+		}
+		w.removeWatches(name)
+		slog.Info("Removed watches for directory", "directory", name, "operation", event.Op.String())
+		w.notify(Change{Path: name, Op: changeOp(event.Op)})
+	}
+}
+
+// recover handles an error reported by the watcher. Events may have been lost, so the watched
+// directory set is rebuilt from disk and the vault is reported to be rescanned.
+func (w *notifyWatch) recover(watchErr error) {
+	if errors.Is(watchErr, fsnotify.ErrEventOverflow) {
+		slog.Warn("File watcher event queue overflowed, rescanning vault")
+	} else {
+		slog.Error("File watcher error, rescanning vault", "error", watchErr)
+	}
+
+	for name := range w.watched {
+		if stat, err := os.Stat(w.path(name)); err != nil || !stat.IsDir() {
+			w.removeWatches(name)
+		}
+	}
+	if _, failed, err := w.addWatches("."); err != nil {
+		slog.Error("Failed to re-add watches", "error", err)
+	} else if failed > 0 {
+		slog.Warn("Some directories are still not watched", "failedDirectories", failed)
+	}
+
+	w.notify(Change{Path: ".", Op: ChangeRescan})
+}
+
+// changeOp maps an fsnotify operation to the change it reports
+func changeOp(op fsnotify.Op) ChangeOp {
+	switch {
+	case op.Has(fsnotify.Create):
+		return ChangeCreate
+	case op.Has(fsnotify.Remove):
+		return ChangeRemove
+	case op.Has(fsnotify.Rename):
+		return ChangeRename
+	default:
+		return ChangeWrite
+	}
+}
@@ -0,0 +1,84 @@
+package clippingsfeed
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestNotifySourcePollsUnwatchedDirectories(t *testing.T) {
+	dir := t.TempDir()
+	unwatched := filepath.Join(dir, "Clippings")
+	assert.NilError(t, os.MkdirAll(unwatched, 0755))
+
+	// The watch limit is reached for Clippings until failing is cleared
+	var failing, watched atomic.Bool
+	failing.Store(true)
+	source := NewNotifySource(dir, 10*time.Millisecond, WalkOptions{})
+	source.addWatch = func(watcher *fsnotify.Watcher, path string) error {
+		if path != unwatched {
+			return watcher.Add(path)
+		}
+		if failing.Load() {
+			return errors.New("no space left on device")
+		}
+		watched.Store(true)
+		return watcher.Add(path)
+	}
+
+	var mu sync.Mutex
+	var changes []Change
+	reported := func(expected Change) poll.Check {
+		return func(poll.LogT) poll.Result {
+			mu.Lock()
+			defer mu.Unlock()
+			if slices.Contains(changes, expected) {
+				return poll.Success()
+			}
+			return poll.Continue("waiting for %v, got %v", expected, changes)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- source.WatchSince(ctx, Snapshot{}, func(change Change) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, change)
+		})
+	}()
+
+	// Once the vault root reports its notes, the watches are in place
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "root.md"), []byte("root"), 0644))
+	poll.WaitOn(t, reported(Change{Path: "root.md", Op: ChangeCreate}))
+
+	// Notes in the directory that is not watched are found by polling it
+	assert.NilError(t, os.WriteFile(filepath.Join(unwatched, "polled.md"), []byte("polled"), 0644))
+	poll.WaitOn(t, reported(Change{Path: "Clippings/polled.md", Op: ChangeCreate}))
+
+	// Once it can be watched, it is watched again
+	failing.Store(false)
+	poll.WaitOn(t, func(poll.LogT) poll.Result {
+		if watched.Load() {
+			return poll.Success()
+		}
+		return poll.Continue("waiting for Clippings to be watched")
+	})
+	assert.NilError(t, os.WriteFile(filepath.Join(unwatched, "watched.md"), []byte("watched"), 0644))
+	poll.WaitOn(t, reported(Change{Path: "Clippings/watched.md", Op: ChangeCreate}))
+
+	cancel()
+	assert.NilError(t, <-done)
+}
@@ -0,0 +1,105 @@
+package clippingsfeed_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func writeNote(t *testing.T, filename, content string) {
+	t.Helper()
+	assert.NilError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+	assert.NilError(t, os.WriteFile(filename, []byte(content), 0644))
+}
+
+func TestNotifySource(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	writeNote(t, filepath.Join(dir, "Clippings", "first.md"), "first")
+	writeNote(t, filepath.Join(dir, ".trash", "old.md"), "old")
+
+	opts := clippingsfeed.WalkOptions{Ignore: clippingsfeed.NewIgnoreMatcher(clippingsfeed.DefaultIgnorePatterns)}
+	source := clippingsfeed.NewNotifySource(dir, time.Minute, opts)
+	snapshot, err := clippingsfeed.TakeSnapshot(t.Context(), source.FS(), ".", opts)
+	assert.NilError(t, err)
+
+	// A note created before watching starts is reported against the earlier snapshot
+	writeNote(t, filepath.Join(dir, "early.md"), "early")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	recorder := &changeRecorder{}
+	done := make(chan error, 1)
+	go func() {
+		done <- source.WatchSince(ctx, snapshot, recorder.notify)
+	}()
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "early.md", Op: clippingsfeed.ChangeCreate}))
+
+	// Notes in ignored directories are not reported
+	writeNote(t, filepath.Join(dir, ".trash", "ignored.md"), "ignored")
+	writeNote(t, filepath.Join(dir, "Clippings", "second.md"), "second")
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Clippings/second.md", Op: clippingsfeed.ChangeCreate}))
+
+	// A directory moved in reports the notes inside it, and its subdirectories are watched
+	moved := filepath.Join(outside, "moved")
+	writeNote(t, filepath.Join(moved, "nested", "inside.md"), "inside")
+	assert.NilError(t, os.Rename(moved, filepath.Join(dir, "moved")))
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "moved/nested/inside.md", Op: clippingsfeed.ChangeCreate}))
+	writeNote(t, filepath.Join(dir, "moved", "nested", "later.md"), "later")
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "moved/nested/later.md", Op: clippingsfeed.ChangeCreate}))
+
+	// A directory moved out is reported with its path
+	assert.NilError(t, os.Rename(filepath.Join(dir, "moved"), filepath.Join(outside, "gone")))
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "moved", Op: clippingsfeed.ChangeRename}))
+
+	assert.NilError(t, os.Remove(filepath.Join(dir, "Clippings", "first.md")))
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Clippings/first.md", Op: clippingsfeed.ChangeRemove}))
+
+	cancel()
+	assert.NilError(t, <-done)
+
+	for _, change := range recorder.changes {
+		assert.Assert(t, !strings.HasPrefix(change.Path, ".trash/"), "unexpected change %v", change)
+	}
+}
+
+func TestNotifySourceSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	shared := t.TempDir()
+	writeNote(t, filepath.Join(shared, "first.md"), "first")
+	assert.NilError(t, os.Symlink(shared, filepath.Join(dir, "Shared")))
+	// A link back to the vault root must not make the watches loop
+	assert.NilError(t, os.Symlink(dir, filepath.Join(shared, "vault")))
+
+	// Changes inside the link target are picked up through the watch on the link
+	opts := clippingsfeed.WalkOptions{FollowSymlinks: true}
+	source := clippingsfeed.NewNotifySource(dir, time.Minute, opts)
+	snapshot, err := clippingsfeed.TakeSnapshot(t.Context(), source.FS(), ".", opts)
+	assert.NilError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	recorder := &changeRecorder{}
+	done := make(chan error, 1)
+	go func() {
+		done <- source.WatchSince(ctx, snapshot, recorder.notify)
+	}()
+
+	writeNote(t, filepath.Join(shared, "second.md"), "second")
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Shared/second.md", Op: clippingsfeed.ChangeCreate}))
+
+	cancel()
+	assert.NilError(t, <-done)
+}
+
+func TestNotifySourceMissingDir(t *testing.T) {
+	source := clippingsfeed.NewNotifySource(filepath.Join(t.TempDir(), "missing"), time.Minute, clippingsfeed.WalkOptions{})
+	assert.ErrorContains(t, source.WatchSince(t.Context(), nil, func(clippingsfeed.Change) {}), "failed to add watches")
+}
@@ -0,0 +1,104 @@
+package clippingsfeed
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/yuin/goldmark"
+)
+
+// FileTimes is returned by the Sys method of the fs.FileInfo of sources that know when a note
+// was created, such as the first commit of a file in git, which unlike the modification time
+// survives copying the vault
+type FileTimes struct {
+	Created time.Time
+}
+
+// ReadNote reads and parses the note at name in fsys. A note without a title is named after
+// its file and one without a created date gets its creation time from FileTimes, or else its
+// modification time.
+func ReadNote(md goldmark.Markdown, fsys fs.FS, name string, info fs.FileInfo) (*Metadata, error) {
+	meta, _, err := readNote(md, fsys, name, info)
+	return meta, err
+}
+
+// readNote is ReadNote also returning the content of the note
+func readNote(md goldmark.Markdown, fsys fs.FS, name string, info fs.FileInfo) (*Metadata, string, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read note: %w", err)
+	}
+	content := string(data)
+
+	meta, err := ParseMeta(md, content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	if meta.Title == "" {
+		meta.Title = path.Base(name)
+	}
+	if meta.Created.IsZero() {
+		meta.Created = info.ModTime()
+		if times, ok := info.Sys().(FileTimes); ok && !times.Created.IsZero() {
+			meta.Created = times.Created
+		}
+	}
+
+	return meta, content, nil
+}
+
+// ScanVault reads every markdown note of the vault in fsys. Alongside the metadata it
+// returns the snapshot the notes were read at, which includes notes that could not be read
+// or parsed, so that comparing it with TakeSnapshot tells whether the vault changed since.
+// Such notes are logged and skipped.
+func ScanVault(ctx context.Context, md goldmark.Markdown, fsys fs.FS, opts WalkOptions) ([]Metadata, Snapshot, error) {
+	return ScanVaultFunc(ctx, md, fsys, opts, nil)
+}
+
+// NoteFunc receives a note read by ScanVaultFunc along with the state it was read at and its
+// content
+type NoteFunc func(name string, state NoteState, meta Metadata, content string)
+
+// ScanVaultFunc is ScanVault calling fn, if not nil, with every note it read, so that the
+// content of the notes can be used without reading them again
+func ScanVaultFunc(ctx context.Context, md goldmark.Markdown, fsys fs.FS, opts WalkOptions, fn NoteFunc) ([]Metadata, Snapshot, error) {
+	var metadata []Metadata
+	snapshot := make(Snapshot)
+
+	err := WalkVault(fsys, ".", opts, func(name string, d fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() || !IsMarkdownFile(name) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			slog.Warn("Error reading file info", "file", name, "error", err)
+			return nil
+		}
+		state := NoteState{Size: info.Size(), ModTime: info.ModTime()}
+		snapshot[name] = state
+
+		meta, content, err := readNote(md, fsys, name, info)
+		if err != nil {
+			slog.Warn("Error reading note", "file", name, "error", err)
+			return nil
+		}
+		if fn != nil {
+			fn(name, state, *meta, content)
+		}
+
+		metadata = append(metadata, *meta)
+		return nil
+	})
+
+	return metadata, snapshot, err
+}
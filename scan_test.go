@@ -0,0 +1,59 @@
+package clippingsfeed_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func TestScanVault(t *testing.T) {
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"Clippings/article.md": {Data: []byte(`---
+title: Test Article
+source: https://example.com/article
+created: 2025-05-01T10:00:00Z
+---
+Body`), ModTime: modTime},
+		"Clippings/untitled.md": {Data: []byte("No frontmatter"), ModTime: modTime},
+		"Clippings/broken.md":   {Data: []byte("---\ntitle:\n  - not\n  - a string\n---\n"), ModTime: modTime},
+		"Templates/clipper.md":  {Data: []byte("---\ntitle: Template\n---\n"), ModTime: modTime},
+		"image.png":             {Data: []byte("png"), ModTime: modTime},
+	}
+	opts := clippingsfeed.WalkOptions{
+		Ignore: clippingsfeed.NewIgnoreMatcher([]string{"Templates/"}),
+	}
+
+	metadata, snapshot, err := clippingsfeed.ScanVault(t.Context(), clippingsfeed.CreateParser(), fsys, opts)
+	assert.NilError(t, err)
+
+	titles := make(map[string]time.Time)
+	for _, meta := range metadata {
+		titles[meta.Title] = meta.Created
+	}
+	assert.DeepEqual(t, titles, map[string]time.Time{
+		"Test Article": time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC),
+		// Named after the file and dated by its modification time
+		"untitled.md": modTime,
+	})
+
+	// Notes that failed to parse are part of the snapshot, so they are not reported as changed
+	expected, err := clippingsfeed.TakeSnapshot(t.Context(), fsys, ".", opts)
+	assert.NilError(t, err)
+	assert.Assert(t, snapshot.Equal(expected))
+	assert.Equal(t, len(snapshot), 3)
+}
+
+func TestScanVaultCancelled(t *testing.T) {
+	fsys := fstest.MapFS{"note.md": {Data: []byte("note")}}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	_, _, err := clippingsfeed.ScanVault(ctx, clippingsfeed.CreateParser(), fsys, clippingsfeed.WalkOptions{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
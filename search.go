@@ -0,0 +1,306 @@
+package clippingsfeed
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Weights of the fields of a note in search scores
+const (
+	searchTitleWeight       = 4
+	searchTagWeight         = 3
+	searchSiteWeight        = 2
+	searchDescriptionWeight = 2
+	searchBodyWeight        = 1
+)
+
+// SearchHit is a note matching a search
+type SearchHit struct {
+	// Path is the slash-separated path of the note in the vault
+	Path     string
+	Metadata Metadata
+	Score    float64
+}
+
+// SearchIndex is an in-memory inverted index over the title, description, tags, site and body
+// of the notes of a vault. It is safe for concurrent use.
+type SearchIndex struct {
+	mu       sync.RWMutex
+	notes    map[string]*searchNote
+	states   map[string]NoteState
+	postings map[string]map[string]float64
+}
+
+type searchNote struct {
+	meta  Metadata
+	terms map[string]float64
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		notes:    make(map[string]*searchNote),
+		states:   make(map[string]NoteState),
+		postings: make(map[string]map[string]float64),
+	}
+}
+
+// Clone returns a copy of the index that can be updated without changing ix, so searches of
+// ix keep seeing the notes as they were indexed until the copy replaces it
+func (ix *SearchIndex) Clone() *SearchIndex {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	clone := &SearchIndex{
+		notes:    maps.Clone(ix.notes),
+		states:   maps.Clone(ix.states),
+		postings: make(map[string]map[string]float64, len(ix.postings)),
+	}
+	for term, postings := range ix.postings {
+		clone.postings[term] = maps.Clone(postings)
+	}
+	return clone
+}
+
+// Index indexes the note at name, read at state, as ScanVaultFunc passes it. A note that was
+// indexed at the same state before is not tokenized again.
+func (ix *SearchIndex) Index(name string, state NoteState, meta Metadata, content string) {
+	ix.mu.RLock()
+	indexed, ok := ix.states[name]
+	ix.mu.RUnlock()
+	if ok && indexed.Equal(state) {
+		return
+	}
+
+	ix.Put(name, meta, NoteBody(content))
+	ix.mu.Lock()
+	ix.states[name] = state
+	ix.mu.Unlock()
+}
+
+// Prune completes indexing the notes of a scan returning snapshot: it drops the notes missing
+// from snapshot, and those whose state in snapshot was not indexed because they could not be
+// read, so they are indexed again by the next scan that reads them.
+func (ix *SearchIndex) Prune(snapshot Snapshot) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for name := range ix.notes {
+		if state, ok := snapshot[name]; !ok || !ix.states[name].Equal(state) {
+			ix.remove(name)
+			delete(ix.states, name)
+		}
+	}
+	for name := range ix.states {
+		if _, ok := snapshot[name]; !ok {
+			delete(ix.states, name)
+		}
+	}
+}
+
+// Put indexes the note at name, replacing what was indexed for it before
+func (ix *SearchIndex) Put(name string, meta Metadata, body string) {
+	terms := make(map[string]float64)
+	addTerms := func(text string, weight float64) {
+		for _, term := range Tokenize(text) {
+			terms[term] += weight
+		}
+	}
+	addTerms(meta.Title, searchTitleWeight)
+	addTerms(meta.Description, searchDescriptionWeight)
+	addTerms(meta.Site, searchSiteWeight)
+	for _, tag := range meta.Tags {
+		addTerms(tag, searchTagWeight)
+	}
+	addTerms(body, searchBodyWeight)
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(name)
+	ix.notes[name] = &searchNote{meta: meta, terms: terms}
+	for term, frequency := range terms {
+		if ix.postings[term] == nil {
+			ix.postings[term] = make(map[string]float64)
+		}
+		ix.postings[term][name] = frequency
+	}
+}
+
+// Remove drops the note at name from the index
+func (ix *SearchIndex) Remove(name string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(name)
+	delete(ix.states, name)
+}
+
+func (ix *SearchIndex) remove(name string) {
+	note, ok := ix.notes[name]
+	if !ok {
+		return
+	}
+	for term := range note.terms {
+		delete(ix.postings[term], name)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	delete(ix.notes, name)
+}
+
+// Len returns the number of indexed notes
+func (ix *SearchIndex) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.notes)
+}
+
+// Search returns the notes containing every term of query, best matches first. A query of a
+// single CJK character matches the words starting with it.
+func (ix *SearchIndex) Search(query string) []SearchHit {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var scores map[string]float64
+	for _, term := range slices.Compact(slices.Sorted(slices.Values(terms))) {
+		matched := make(map[string]float64)
+		for _, postings := range ix.termPostings(term) {
+			idf := math.Log(1 + float64(len(ix.notes))/float64(len(postings)))
+			for name, frequency := range postings {
+				if scores == nil || scores[name] > 0 {
+					matched[name] = max(matched[name], idf*frequency/(frequency+1.2))
+				}
+			}
+		}
+		for name, score := range matched {
+			matched[name] = score + scores[name]
+		}
+		scores = matched
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for name, score := range scores {
+		hits = append(hits, SearchHit{Path: name, Metadata: ix.notes[name].meta, Score: score})
+	}
+	slices.SortFunc(hits, func(a, b SearchHit) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), b.Metadata.Created.Compare(a.Metadata.Created), strings.Compare(a.Path, b.Path))
+	})
+	return hits
+}
+
+// termPostings returns the postings of term, or of every term starting with it when it is a
+// single CJK character, which is shorter than the bigrams CJK text is indexed by
+func (ix *SearchIndex) termPostings(term string) []map[string]float64 {
+	r, size := utf8.DecodeRuneInString(term)
+	if size != len(term) || !isCJK(r) {
+		if postings, ok := ix.postings[term]; ok {
+			return []map[string]float64{postings}
+		}
+		return nil
+	}
+
+	var matched []map[string]float64
+	for indexed, postings := range ix.postings {
+		if strings.HasPrefix(indexed, term) {
+			matched = append(matched, postings)
+		}
+	}
+	return matched
+}
+
+// Tokenize splits text into lowercase search terms. Words are separated by anything but
+// letters and digits, and runs of CJK characters, which are not separated by spaces, are
+// split into overlapping bigrams, so "全文検索" yields "全文", "文検" and "検索". Fullwidth
+// ASCII is folded to ASCII.
+func Tokenize(text string) []string {
+	var terms []string
+	var word []rune
+	var cjk []rune
+
+	flush := func() {
+		if len(word) > 0 {
+			terms = append(terms, string(word))
+			word = word[:0]
+		}
+		switch {
+		case len(cjk) == 1:
+			terms = append(terms, string(cjk))
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				terms = append(terms, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFEE0
+		}
+		r = unicode.ToLower(r)
+
+		switch {
+		case isCJK(r):
+			if len(word) > 0 {
+				flush()
+			}
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || (unicode.Is(unicode.Mn, r) && len(word) > 0):
+			if len(cjk) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return terms
+}
+
+// isCJK reports whether r is indexed by bigrams: Han, Hiragana, Katakana, including the
+// prolonged sound mark, and Hangul
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) || r == 'ー' || r == 'ｰ'
+}
+
+// NoteBody returns content without its front matter
+func NoteBody(content string) string {
+	rest, ok := strings.CutPrefix(content, "---")
+	if !ok {
+		return content
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(rest, "\n") && !strings.HasPrefix(rest, "\r\n") {
+		return content
+	}
+
+	for offset := 0; offset < len(rest); {
+		end := strings.IndexByte(rest[offset:], '\n')
+		if end < 0 {
+			end = len(rest) - offset
+		}
+		line := strings.TrimRight(rest[offset:offset+end], " \t\r")
+		offset += end + 1
+		if line == "---" {
+			return rest[min(offset, len(rest)):]
+		}
+	}
+	return content
+}
@@ -0,0 +1,169 @@
+package clippingsfeed_test
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := map[string][]string{
+		"Hello, World!":         {"hello", "world"},
+		"Go 1.24 release":       {"go", "1", "24", "release"},
+		"全文検索":                  {"全文", "文検", "検索"},
+		"Go言語で全文検索":             {"go", "言語", "語で", "で全", "全文", "文検", "検索"},
+		"ＧＯ　ｌａｎｇ":               {"go", "lang"},
+		"データベース":                {"デー", "ータ", "タベ", "ベー", "ース"},
+		"猫":                     {"猫"},
+		"café naïve":            {"café", "naïve"},
+		"":                      nil,
+		"--- #tag [[link]] ---": {"tag", "link"},
+	}
+
+	for text, expected := range tests {
+		assert.DeepEqual(t, clippingsfeed.Tokenize(text), expected)
+	}
+}
+
+func TestNoteBody(t *testing.T) {
+	tests := map[string]string{
+		"---\ntitle: Note\n---\nbody\n":     "body\n",
+		"---\r\ntitle: Note\r\n---\r\nbody": "body",
+		"no front matter":                   "no front matter",
+		"---\nunterminated":                 "---\nunterminated",
+		"----\nnot front matter\n---\n":     "----\nnot front matter\n---\n",
+	}
+
+	for content, expected := range tests {
+		assert.Equal(t, clippingsfeed.NoteBody(content), expected, content)
+	}
+}
+
+func searchPaths(hits []clippingsfeed.SearchHit) []string {
+	var paths []string
+	for _, hit := range hits {
+		paths = append(paths, hit.Path)
+	}
+	return paths
+}
+
+func TestSearchIndex(t *testing.T) {
+	index := clippingsfeed.NewSearchIndex()
+	index.Put("generics.md", clippingsfeed.Metadata{Title: "Go Generics", Tags: []string{"golang"}}, "Type parameters in depth")
+	index.Put("search.md", clippingsfeed.Metadata{Title: "全文検索エンジンの仕組み", Site: "Tech Blog"}, "転置インデックスを使う")
+	index.Put("notes.md", clippingsfeed.Metadata{Title: "Reading notes", Description: "Mentions generics once"}, "")
+
+	tests := map[string][]string{
+		"generics":    {"generics.md", "notes.md"},
+		"GENERICS":    {"generics.md", "notes.md"},
+		"go generics": {"generics.md"},
+		"golang":      {"generics.md"},
+		"parameters":  {"generics.md"},
+		"検索":          {"search.md"},
+		"全文検索":        {"search.md"},
+		"インデックス":      {"search.md"},
+		"検":           {"search.md"},
+		"tech blog":   {"search.md"},
+		"検索 missing":  nil,
+		"":            nil,
+		"unknownterm": nil,
+	}
+
+	for query, expected := range tests {
+		assert.DeepEqual(t, searchPaths(index.Search(query)), expected)
+	}
+
+	// Putting a note again replaces it
+	index.Put("notes.md", clippingsfeed.Metadata{Title: "Reading notes"}, "")
+	assert.DeepEqual(t, searchPaths(index.Search("generics")), []string{"generics.md"})
+
+	index.Remove("generics.md")
+	assert.Assert(t, index.Search("generics") == nil)
+	assert.Equal(t, index.Len(), 2)
+}
+
+func TestSearchIndexClone(t *testing.T) {
+	index := clippingsfeed.NewSearchIndex()
+	index.Put("generics.md", clippingsfeed.Metadata{Title: "Go Generics"}, "Type parameters")
+	index.Put("notes.md", clippingsfeed.Metadata{Title: "Reading notes"}, "generics once")
+
+	clone := index.Clone()
+	clone.Put("generics.md", clippingsfeed.Metadata{Title: "Go Iterators"}, "range over func")
+	clone.Remove("notes.md")
+
+	assert.DeepEqual(t, searchPaths(index.Search("generics")), []string{"generics.md", "notes.md"})
+	assert.Assert(t, index.Search("iterators") == nil)
+	assert.Equal(t, index.Len(), 2)
+	assert.Assert(t, clone.Search("generics") == nil)
+	assert.DeepEqual(t, searchPaths(clone.Search("iterators")), []string{"generics.md"})
+}
+
+// countingFS counts how often each file of a MapFS is read
+type countingFS struct {
+	fstest.MapFS
+	reads map[string]int
+}
+
+func (c countingFS) Open(name string) (fs.File, error) {
+	c.reads[name]++
+	return c.MapFS.Open(name)
+}
+
+func (c countingFS) ReadFile(name string) ([]byte, error) {
+	c.reads[name]++
+	return c.MapFS.ReadFile(name)
+}
+
+func TestSearchIndexScan(t *testing.T) {
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fsys := countingFS{
+		MapFS: fstest.MapFS{
+			"first.md":  {Data: []byte("---\ntitle: First\n---\nmentions an aardvark\n"), ModTime: modTime},
+			"second.md": {Data: []byte("---\ntitle: Second\n---\nabout zebras\n"), ModTime: modTime},
+		},
+		reads: make(map[string]int),
+	}
+
+	md := clippingsfeed.CreateParser()
+	index := clippingsfeed.NewSearchIndex()
+	scan := func() {
+		t.Helper()
+		_, snapshot, err := clippingsfeed.ScanVaultFunc(t.Context(), md, fsys, clippingsfeed.WalkOptions{}, index.Index)
+		assert.NilError(t, err)
+		index.Prune(snapshot)
+	}
+
+	scan()
+	assert.DeepEqual(t, searchPaths(index.Search("aardvark")), []string{"first.md"})
+	assert.Equal(t, index.Search("first")[0].Metadata.Title, "First")
+	// Indexing uses the content the scan read
+	assert.DeepEqual(t, fsys.reads, map[string]int{"first.md": 1, "second.md": 1})
+
+	// A changed note is indexed again, a removed one is dropped
+	later := modTime.Add(time.Minute)
+	fsys.MapFS["first.md"] = &fstest.MapFile{Data: []byte("---\ntitle: First\n---\nnow about an okapi\n"), ModTime: later}
+	delete(fsys.MapFS, "second.md")
+	scan()
+
+	assert.Assert(t, index.Search("aardvark") == nil)
+	assert.DeepEqual(t, searchPaths(index.Search("okapi")), []string{"first.md"})
+	assert.Assert(t, index.Search("zebras") == nil)
+	assert.Equal(t, index.Len(), 1)
+
+	// A note that fails to parse is dropped, and indexed once it parses again even if its
+	// size and modification time are the same
+	broken := []byte("---\ntitle:\n  - not\n  - text\n---\nabout a quokka\n")
+	fixed := []byte("---\ntitle: Fixed at last!\n---\n\n\nabout a quokka\n")
+	assert.Equal(t, len(broken), len(fixed))
+	fsys.MapFS["first.md"] = &fstest.MapFile{Data: broken, ModTime: later.Add(time.Minute)}
+	scan()
+	assert.Equal(t, index.Len(), 0)
+
+	fsys.MapFS["first.md"] = &fstest.MapFile{Data: fixed, ModTime: later.Add(time.Minute)}
+	scan()
+	assert.DeepEqual(t, searchPaths(index.Search("quokka")), []string{"first.md"})
+}
@@ -0,0 +1,113 @@
+package clippingsfeed
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NoteState is what is compared to tell whether a note changed
+type NoteState struct {
+	Size    int64
+	ModTime time.Time
+}
+
+func (s NoteState) Equal(other NoteState) bool {
+	return s.Size == other.Size && s.ModTime.Equal(other.ModTime)
+}
+
+// Snapshot maps the slash-separated path of every markdown note in a vault to its state
+type Snapshot map[string]NoteState
+
+func (s Snapshot) Equal(other Snapshot) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for name, state := range s {
+		otherState, ok := other[name]
+		if !ok || !otherState.Equal(state) {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffSnapshots describes how a vault changed from old to current. A note that disappeared
+// and one that appeared with the same size and modification time are reported as a rename
+// of the old path followed by the creation of the new one.
+func DiffSnapshots(old, current Snapshot) []Change {
+	var created, removed []string
+	var changes []Change
+
+	for name, state := range current {
+		oldState, ok := old[name]
+		switch {
+		case !ok:
+			created = append(created, name)
+		case !oldState.Equal(state):
+			changes = append(changes, Change{Path: name, Op: ChangeWrite})
+		}
+	}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(created)
+	sort.Strings(removed)
+
+	for _, oldName := range removed {
+		i := slices.IndexFunc(created, func(newName string) bool {
+			return old[oldName].Equal(current[newName])
+		})
+		if i < 0 {
+			changes = append(changes, Change{Path: oldName, Op: ChangeRemove})
+			continue
+		}
+
+		changes = append(changes,
+			Change{Path: oldName, Op: ChangeRename},
+			Change{Path: created[i], Op: ChangeCreate},
+		)
+		created = slices.Delete(created, i, i+1)
+	}
+	for _, name := range created {
+		changes = append(changes, Change{Path: name, Op: ChangeCreate})
+	}
+
+	return changes
+}
+
+// TakeSnapshot records the state of every markdown note below root in fsys without
+// reading them
+func TakeSnapshot(ctx context.Context, fsys fs.FS, root string, opts WalkOptions) (Snapshot, error) {
+	snapshot := make(Snapshot)
+
+	err := WalkVault(fsys, root, opts, func(name string, d fs.DirEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() || !IsMarkdownFile(name) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// The note disappeared while walking
+			return nil
+		}
+		snapshot[name] = NoteState{Size: info.Size(), ModTime: info.ModTime()}
+		return nil
+	})
+
+	return snapshot, err
+}
+
+// IsMarkdownFile reports whether name has a .md extension, in any case
+func IsMarkdownFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".md")
+}
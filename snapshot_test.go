@@ -0,0 +1,126 @@
+package clippingsfeed_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func TestTakeSnapshot(t *testing.T) {
+	baseTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"note.md":              {Data: []byte("note"), ModTime: baseTime},
+		"sub/deep.MD":          {Data: []byte("deep note"), ModTime: baseTime},
+		"image.png":            {Data: []byte("png")},
+		".obsidian/config.md":  {Data: []byte("config")},
+		".trash/deleted.md":    {Data: []byte("deleted")},
+		"Templates/clipper.md": {Data: []byte("template")},
+	}
+	opts := clippingsfeed.WalkOptions{
+		Ignore: clippingsfeed.NewIgnoreMatcher(append(clippingsfeed.DefaultIgnorePatterns, "Templates/")),
+	}
+
+	snapshot, err := clippingsfeed.TakeSnapshot(t.Context(), fsys, ".", opts)
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, snapshot, clippingsfeed.Snapshot{
+		"note.md":     {Size: int64(len("note")), ModTime: baseTime},
+		"sub/deep.MD": {Size: int64(len("deep note")), ModTime: baseTime},
+	})
+}
+
+func TestSnapshotEqual(t *testing.T) {
+	baseTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	base := clippingsfeed.Snapshot{
+		"a.md": {Size: 1, ModTime: baseTime},
+		"b.md": {Size: 2, ModTime: baseTime},
+	}
+
+	tests := []struct {
+		name     string
+		other    clippingsfeed.Snapshot
+		expected bool
+	}{
+		{
+			name: "equal",
+			other: clippingsfeed.Snapshot{
+				"a.md": {Size: 1, ModTime: baseTime.In(time.Local)},
+				"b.md": {Size: 2, ModTime: baseTime},
+			},
+			expected: true,
+		},
+		{
+			name:     "removed",
+			other:    clippingsfeed.Snapshot{"a.md": {Size: 1, ModTime: baseTime}},
+			expected: false,
+		},
+		{
+			name: "renamed",
+			other: clippingsfeed.Snapshot{
+				"a.md": {Size: 1, ModTime: baseTime},
+				"c.md": {Size: 2, ModTime: baseTime},
+			},
+			expected: false,
+		},
+		{
+			name: "modified",
+			other: clippingsfeed.Snapshot{
+				"a.md": {Size: 1, ModTime: baseTime.Add(time.Second)},
+				"b.md": {Size: 2, ModTime: baseTime},
+			},
+			expected: false,
+		},
+		{
+			name: "resized",
+			other: clippingsfeed.Snapshot{
+				"a.md": {Size: 3, ModTime: baseTime},
+				"b.md": {Size: 2, ModTime: baseTime},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, base.Equal(tt.other), tt.expected)
+		})
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	baseTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	old := clippingsfeed.Snapshot{
+		"kept.md":     {Size: 1, ModTime: baseTime},
+		"modified.md": {Size: 2, ModTime: baseTime},
+		"removed.md":  {Size: 3, ModTime: baseTime},
+		"moved.md":    {Size: 4, ModTime: baseTime},
+	}
+	current := clippingsfeed.Snapshot{
+		"kept.md":       {Size: 1, ModTime: baseTime},
+		"modified.md":   {Size: 2, ModTime: baseTime.Add(time.Minute)},
+		"folder/new.md": {Size: 4, ModTime: baseTime},
+		"created.md":    {Size: 5, ModTime: baseTime},
+	}
+
+	got := make(map[string]clippingsfeed.ChangeOp)
+	for _, change := range clippingsfeed.DiffSnapshots(old, current) {
+		got[change.Path] = change.Op
+	}
+
+	assert.DeepEqual(t, got, map[string]clippingsfeed.ChangeOp{
+		"modified.md":   clippingsfeed.ChangeWrite,
+		"removed.md":    clippingsfeed.ChangeRemove,
+		"moved.md":      clippingsfeed.ChangeRename,
+		"folder/new.md": clippingsfeed.ChangeCreate,
+		"created.md":    clippingsfeed.ChangeCreate,
+	})
+}
+
+func TestDiffSnapshotsUnchanged(t *testing.T) {
+	snapshot := clippingsfeed.Snapshot{"note.md": {Size: 1, ModTime: time.Now()}}
+
+	assert.Equal(t, len(clippingsfeed.DiffSnapshots(snapshot, snapshot)), 0)
+}
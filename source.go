@@ -0,0 +1,149 @@
+package clippingsfeed
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"time"
+)
+
+// ChangeOp is the kind of change made to a note
+type ChangeOp int
+
+const (
+	ChangeCreate ChangeOp = iota + 1
+	ChangeWrite
+	ChangeRemove
+	ChangeRename
+	// ChangeRescan reports that changes below Path, a directory, may have been missed, e.g.
+	// because the events reporting them were lost, so the notes below it need to be read again
+	ChangeRescan
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeCreate:
+		return "CREATE"
+	case ChangeWrite:
+		return "WRITE"
+	case ChangeRemove:
+		return "REMOVE"
+	case ChangeRename:
+		return "RENAME"
+	case ChangeRescan:
+		return "RESCAN"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Change reports that the note at Path, slash-separated and relative to the vault root,
+// changed. Sources watching directories may also report a removed or renamed directory,
+// which takes the notes below it along.
+type Change struct {
+	Path string
+	Op   ChangeOp
+}
+
+// Source is a vault that feeds are built from: FS exposes its notes and Watch reports when
+// they change. Sources can be backed by a directory, an embedded or archived vault, an
+// in-memory filesystem or a remote service.
+type Source interface {
+	// FS returns the vault with its root at "."
+	FS() fs.FS
+
+	// Watch calls notify for every changed note until ctx is done, then returns nil. Calls
+	// to notify are never concurrent.
+	Watch(ctx context.Context, notify func(Change)) error
+}
+
+type fsSource struct {
+	fsys fs.FS
+}
+
+// NewFSSource returns a Source for a vault that does not change, such as an embedded test
+// vault or an archive. Its Watch only waits for ctx to be done.
+func NewFSSource(fsys fs.FS) Source {
+	return fsSource{fsys: fsys}
+}
+
+func (s fsSource) FS() fs.FS {
+	return s.fsys
+}
+
+func (s fsSource) Watch(ctx context.Context, _ func(Change)) error {
+	<-ctx.Done()
+	return nil
+}
+
+// PollingSource detects changes by comparing snapshots of its filesystem every interval, for
+// filesystems that do not deliver change events such as NFS, SMB or some bind mounts
+type PollingSource struct {
+	fsys     fs.FS
+	interval time.Duration
+	opts     WalkOptions
+}
+
+// NewPollingSource returns a Source polling fsys every interval. The notes compared are the
+// ones WalkVault visits with opts.
+func NewPollingSource(fsys fs.FS, interval time.Duration, opts WalkOptions) *PollingSource {
+	return &PollingSource{fsys: fsys, interval: interval, opts: opts}
+}
+
+func (s *PollingSource) FS() fs.FS {
+	return s.fsys
+}
+
+// Watch reports the changes found since the previous poll, starting from the state of the
+// vault when Watch is called. A failed poll is logged and retried on the next tick.
+func (s *PollingSource) Watch(ctx context.Context, notify func(Change)) error {
+	previous, err := TakeSnapshot(ctx, s.fsys, ".", s.opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Error("Failed to poll vault", "error", err)
+	}
+	return s.WatchSince(ctx, previous, notify)
+}
+
+// WatchSince is Watch starting from a known state of the vault, typically the snapshot
+// returned by ScanVault, so that changes made before watching started are reported too
+func (s *PollingSource) WatchSince(ctx context.Context, previous Snapshot, notify func(Change)) error {
+	if s.interval <= 0 {
+		return errors.New("poll interval must be positive")
+	}
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			current, err := TakeSnapshot(ctx, s.fsys, ".", s.opts)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Failed to poll vault", "error", err)
+				}
+				continue
+			}
+
+			for _, change := range DiffSnapshots(previous, current) {
+				notify(change)
+			}
+			previous = current
+		}
+	}
+}
+
+// SyncSource is a Source whose notes live elsewhere, such as in a database or on a server.
+// Sync fetches the changes made since the previous call; consumers call it before reading FS
+// so that a build reflects the remote vault, while Watch keeps FS up to date in between.
+type SyncSource interface {
+	Source
+	Sync(ctx context.Context) error
+}
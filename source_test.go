@@ -0,0 +1,98 @@
+package clippingsfeed_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+func TestFSSource(t *testing.T) {
+	fsys := fstest.MapFS{"note.md": {Data: []byte("---\ntitle: Note\nsource: https://example.com\n---\n")}}
+	source := clippingsfeed.NewFSSource(fsys)
+
+	metadata, _, err := clippingsfeed.ScanVault(t.Context(), clippingsfeed.CreateParser(), source.FS(), clippingsfeed.WalkOptions{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(metadata), 1)
+	assert.Equal(t, metadata[0].Title, "Note")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.NilError(t, source.Watch(ctx, func(change clippingsfeed.Change) {
+		t.Errorf("Unexpected change %v", change)
+	}))
+}
+
+// changeRecorder collects the changes reported by a source
+type changeRecorder struct {
+	mu      sync.Mutex
+	changes []clippingsfeed.Change
+}
+
+func (r *changeRecorder) notify(change clippingsfeed.Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, change)
+}
+
+func (r *changeRecorder) has(expected clippingsfeed.Change) poll.Check {
+	return func(poll.LogT) poll.Result {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, change := range r.changes {
+			if change == expected {
+				return poll.Success()
+			}
+		}
+		return poll.Continue("waiting for %v, got %v", expected, r.changes)
+	}
+}
+
+func TestPollingSource(t *testing.T) {
+	dir := t.TempDir()
+	note := filepath.Join(dir, "note.md")
+	assert.NilError(t, os.WriteFile(note, []byte("note"), 0644))
+
+	source := clippingsfeed.NewPollingSource(os.DirFS(dir), 10*time.Millisecond, clippingsfeed.WalkOptions{
+		Ignore: clippingsfeed.NewIgnoreMatcher(clippingsfeed.DefaultIgnorePatterns),
+	})
+	snapshot, err := clippingsfeed.TakeSnapshot(t.Context(), source.FS(), ".", clippingsfeed.WalkOptions{})
+	assert.NilError(t, err)
+
+	// A note created before watching starts is reported against the earlier snapshot
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "early.md"), []byte("early"), 0644))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	recorder := &changeRecorder{}
+	done := make(chan error, 1)
+	go func() {
+		done <- source.WatchSince(ctx, snapshot, recorder.notify)
+	}()
+
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "early.md", Op: clippingsfeed.ChangeCreate}))
+
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, ".trash"), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, ".trash", "ignored.md"), []byte("ignored"), 0644))
+	assert.NilError(t, os.Remove(note))
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "note.md", Op: clippingsfeed.ChangeRemove}))
+
+	cancel()
+	assert.NilError(t, <-done)
+
+	for _, change := range recorder.changes {
+		assert.Assert(t, change.Path != ".trash/ignored.md", "ignored note was reported")
+	}
+}
+
+func TestPollingSourceInvalidInterval(t *testing.T) {
+	source := clippingsfeed.NewPollingSource(fstest.MapFS{}, 0, clippingsfeed.WalkOptions{})
+	assert.ErrorContains(t, source.WatchSince(t.Context(), nil, func(clippingsfeed.Change) {}), "poll interval")
+}
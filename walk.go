@@ -0,0 +1,129 @@
+package clippingsfeed
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+)
+
+// WalkOptions controls which paths of a vault are visited
+type WalkOptions struct {
+	// Ignore skips the matching paths and everything below them
+	Ignore *IgnoreMatcher
+
+	// FollowSymlinks descends into symlinked directories under their path in the vault. It
+	// needs a filesystem whose Stat and ReadDir resolve symlinks, such as os.DirFS.
+	FollowSymlinks bool
+}
+
+// Ignored reports whether the slash-separated path relative to the vault root is excluded
+func (o WalkOptions) Ignored(name string, isDir bool) bool {
+	if name == "." || name == "" {
+		return false
+	}
+	return o.Ignore.Match(name, isDir)
+}
+
+// WalkVault calls fn for root and every path below it in fsys that is not ignored, in
+// lexical order. Like fs.WalkDir, paths are slash-separated and fn may return fs.SkipDir to
+// skip a directory or fs.SkipAll to stop. Unlike it, root is resolved when it is a symlink.
+//
+// When following symlinks, each directory is visited once, so a symlink pointing back at one
+// of its ancestors, or two symlinks to the same directory, cannot make the walk loop or
+// repeat notes. Broken symlinks are skipped.
+func WalkVault(fsys fs.FS, root string, opts WalkOptions, fn func(path string, d fs.DirEntry) error) error {
+	info, err := fs.Stat(fsys, root)
+	if err != nil {
+		return err
+	}
+
+	w := &vaultWalk{fsys: fsys, opts: opts, fn: fn}
+	err = w.walk(root, fs.FileInfoToDirEntry(info))
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+type vaultWalk struct {
+	fsys fs.FS
+	opts WalkOptions
+	fn   func(path string, d fs.DirEntry) error
+
+	// visited holds the directories walked so far when following symlinks. Only filesystems
+	// backed by the OS can tell whether two of them are the same, which is where symlinks
+	// exist in the first place.
+	visited  []fs.FileInfo
+	followed int
+}
+
+func (w *vaultWalk) walk(name string, d fs.DirEntry) error {
+	if w.opts.Ignored(name, d.IsDir()) {
+		return nil
+	}
+
+	if err := w.fn(name, d); err != nil {
+		if errors.Is(err, fs.SkipDir) && d.IsDir() {
+			return nil
+		}
+		return err
+	}
+	if !d.IsDir() {
+		return nil
+	}
+
+	if w.opts.FollowSymlinks {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if w.seen(info) {
+			slog.Warn("Skipping directory that was already visited through a symlink", "directory", name)
+			return nil
+		}
+		w.visited = append(w.visited, info)
+	}
+
+	entries, err := fs.ReadDir(w.fsys, name)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		child := path.Join(name, entry.Name())
+
+		if w.opts.FollowSymlinks && entry.Type()&fs.ModeSymlink != 0 {
+			info, err := fs.Stat(w.fsys, child)
+			if err != nil {
+				slog.Warn("Skipping broken symlink", "path", child, "error", err)
+				continue
+			}
+			if info.IsDir() {
+				w.followed++
+			}
+			entry = fs.FileInfoToDirEntry(info)
+		}
+
+		if err := w.walk(child, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seen reports whether the directory was already walked. Without a followed symlink no
+// directory can be reached twice, so the comparison is skipped until one is followed.
+func (w *vaultWalk) seen(info fs.FileInfo) bool {
+	if w.followed == 0 {
+		return false
+	}
+	for _, visited := range w.visited {
+		if os.SameFile(visited, info) {
+			return true
+		}
+	}
+	return false
+}
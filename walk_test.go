@@ -0,0 +1,104 @@
+package clippingsfeed_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func walkVaultPaths(t *testing.T, fsys fs.FS, root string, opts clippingsfeed.WalkOptions) []string {
+	t.Helper()
+
+	var paths []string
+	err := clippingsfeed.WalkVault(fsys, root, opts, func(name string, d fs.DirEntry) error {
+		if d.IsDir() {
+			name += "/"
+		}
+		paths = append(paths, name)
+		return nil
+	})
+	assert.NilError(t, err)
+	return paths
+}
+
+func TestWalkVault(t *testing.T) {
+	fsys := fstest.MapFS{
+		"note.md":                    {Data: []byte("note")},
+		"Clippings/b.md":             {Data: []byte("b")},
+		"Clippings/a.md":             {Data: []byte("a")},
+		"Templates/clipper.md":       {Data: []byte("template")},
+		".obsidian/workspace.json":   {Data: []byte("{}")},
+		".trash/deleted.md":          {Data: []byte("deleted")},
+		"Clippings/image.excalidraw": {Data: []byte("image")},
+	}
+	opts := clippingsfeed.WalkOptions{
+		Ignore: clippingsfeed.NewIgnoreMatcher(append(clippingsfeed.DefaultIgnorePatterns, "Templates/", "*.excalidraw")),
+	}
+
+	assert.DeepEqual(t, walkVaultPaths(t, fsys, ".", opts), []string{
+		"./", "Clippings/", "Clippings/a.md", "Clippings/b.md", "note.md",
+	})
+	assert.DeepEqual(t, walkVaultPaths(t, fsys, "Clippings", opts), []string{
+		"Clippings/", "Clippings/a.md", "Clippings/b.md",
+	})
+}
+
+func TestWalkVaultSkip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/1.md": {Data: []byte("1")},
+		"b/2.md": {Data: []byte("2")},
+		"c/3.md": {Data: []byte("3")},
+	}
+
+	var paths []string
+	err := clippingsfeed.WalkVault(fsys, ".", clippingsfeed.WalkOptions{}, func(name string, d fs.DirEntry) error {
+		switch name {
+		case "a":
+			return fs.SkipDir
+		case "b/2.md":
+			paths = append(paths, name)
+			return fs.SkipAll
+		}
+		paths = append(paths, name)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, paths, []string{".", "b", "b/2.md"})
+}
+
+func TestWalkVaultMissingRoot(t *testing.T) {
+	err := clippingsfeed.WalkVault(fstest.MapFS{}, "missing", clippingsfeed.WalkOptions{}, func(string, fs.DirEntry) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestWalkVaultSymlinks(t *testing.T) {
+	vaultDir := t.TempDir()
+	otherVault := t.TempDir()
+
+	assert.NilError(t, os.WriteFile(filepath.Join(vaultDir, "note.md"), []byte("note"), 0644))
+	assert.NilError(t, os.MkdirAll(filepath.Join(otherVault, "Clippings"), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(otherVault, "Clippings", "linked.md"), []byte("linked"), 0644))
+	assert.NilError(t, os.Symlink(filepath.Join(otherVault, "Clippings"), filepath.Join(vaultDir, "Shared")))
+	assert.NilError(t, os.Symlink(filepath.Join(otherVault, "Clippings"), filepath.Join(vaultDir, "SharedAgain")))
+	assert.NilError(t, os.Symlink(vaultDir, filepath.Join(otherVault, "Clippings", "loop")))
+	assert.NilError(t, os.Symlink(filepath.Join(otherVault, "missing"), filepath.Join(vaultDir, "broken")))
+
+	fsys := os.DirFS(vaultDir)
+
+	assert.DeepEqual(t, walkVaultPaths(t, fsys, ".", clippingsfeed.WalkOptions{}), []string{
+		"./", "Shared", "SharedAgain", "broken", "note.md",
+	})
+
+	// The loop back to the vault root and the second link to the same folder are visited
+	// once and not descended into
+	assert.DeepEqual(t, walkVaultPaths(t, fsys, ".", clippingsfeed.WalkOptions{FollowSymlinks: true}), []string{
+		"./", "Shared/", "Shared/linked.md", "Shared/loop/", "SharedAgain/", "note.md",
+	})
+}
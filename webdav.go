@@ -0,0 +1,314 @@
+package clippingsfeed
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const webDAVDefaultPollInterval = 30 * time.Second
+
+// webDAVPropfind asks for the properties needed to list notes and tell whether they changed
+const webDAVPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getetag/><D:getlastmodified/><D:getcontentlength/></D:prop></D:propfind>`
+
+// WebDAVConfig configures a WebDAVSource
+type WebDAVConfig struct {
+	// URL of the vault collection, e.g. https://dav.example.com/remotely-save/vault/.
+	// Credentials in the URL are sent with basic authentication.
+	URL string
+
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+
+	// PollInterval is how often Watch lists the vault for changes, 30 seconds when zero
+	PollInterval time.Duration
+
+	// Ignore skips directories that should not be listed, such as .obsidian
+	Ignore *IgnoreMatcher
+}
+
+// WebDAVSource reads a vault from a WebDAV server, such as one the Remotely Save plugin
+// synchronizes to. The vault is listed with PROPFIND and a note is only downloaded again when
+// its ETag, or its size and modification time for servers without ETags, changed.
+type WebDAVSource struct {
+	root     *url.URL
+	user     *url.Userinfo
+	client   *http.Client
+	interval time.Duration
+	ignore   *IgnoreMatcher
+
+	// mu serializes refreshes
+	mu    sync.Mutex
+	notes map[string]webDAVNote
+	fsys  atomic.Pointer[MemFS]
+}
+
+type webDAVNote struct {
+	etag    string
+	size    int64
+	modTime time.Time
+	data    []byte
+}
+
+// unchanged reports whether listed describes the same version of the note
+func (n webDAVNote) unchanged(listed webDAVNote) bool {
+	if n.etag != "" || listed.etag != "" {
+		return n.etag == listed.etag
+	}
+	return n.size == listed.size && n.modTime.Equal(listed.modTime)
+}
+
+type webDAVMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ETag          string `xml:"DAV: getetag"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// NewWebDAVSource returns a source for the collection at config.URL. Nothing is fetched until
+// Sync or Watch is called.
+func NewWebDAVSource(config WebDAVConfig) (*WebDAVSource, error) {
+	root, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV URL: %w", err)
+	}
+	if root.Scheme != "http" && root.Scheme != "https" {
+		return nil, fmt.Errorf("invalid WebDAV URL %s: scheme must be http or https", root.Redacted())
+	}
+
+	s := &WebDAVSource{
+		root:     root,
+		user:     root.User,
+		client:   config.Client,
+		interval: config.PollInterval,
+		ignore:   config.Ignore,
+		notes:    make(map[string]webDAVNote),
+	}
+	s.root.User = nil
+	s.root.RawQuery, s.root.Fragment = "", ""
+	if !strings.HasSuffix(s.root.Path, "/") {
+		s.root.Path += "/"
+		s.root.RawPath = ""
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.interval <= 0 {
+		s.interval = webDAVDefaultPollInterval
+	}
+	s.fsys.Store(NewMemFS(nil))
+
+	return s, nil
+}
+
+// FS returns the notes fetched by the last refresh
+func (s *WebDAVSource) FS() fs.FS {
+	return s.fsys.Load()
+}
+
+// Sync lists the vault and downloads the notes that changed since the previous call
+func (s *WebDAVSource) Sync(ctx context.Context) error {
+	_, err := s.refresh(ctx)
+	return err
+}
+
+// Watch refreshes the vault every poll interval until ctx is done. Failures are logged and
+// retried on the next tick.
+func (s *WebDAVSource) Watch(ctx context.Context, notify func(Change)) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			changes, err := s.refresh(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Failed to poll WebDAV vault", "url", s.root.String(), "error", err)
+				}
+				continue
+			}
+			for _, change := range changes {
+				notify(change)
+			}
+		}
+	}
+}
+
+// refresh lists the vault, downloads new and changed notes and returns the changes
+func (s *WebDAVSource) refresh(ctx context.Context) ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listed := make(map[string]webDAVNote)
+	if err := s.list(ctx, "", listed); err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	notes := make(map[string]webDAVNote, len(listed))
+	for name, note := range listed {
+		previous, ok := s.notes[name]
+		if ok && previous.unchanged(note) {
+			notes[name] = previous
+			continue
+		}
+
+		data, err := s.get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		note.data = data
+		notes[name] = note
+
+		if ok {
+			changes = append(changes, Change{Path: name, Op: ChangeWrite})
+		} else {
+			changes = append(changes, Change{Path: name, Op: ChangeCreate})
+		}
+	}
+	for name := range s.notes {
+		if _, ok := notes[name]; !ok {
+			changes = append(changes, Change{Path: name, Op: ChangeRemove})
+		}
+	}
+
+	files := make(map[string]MemFile, len(notes))
+	for name, note := range notes {
+		files[name] = MemFile{Data: note.data, ModTime: note.modTime}
+	}
+	s.notes = notes
+	s.fsys.Store(NewMemFS(files))
+
+	return changes, nil
+}
+
+// list adds the notes below dir, a slash-terminated path relative to the root, to notes
+func (s *WebDAVSource) list(ctx context.Context, dir string, notes map[string]webDAVNote) error {
+	u := s.url(dir)
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", u.String(), strings.NewReader(webDAVPropfind))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	var status webDAVMultistatus
+	if err := s.do(req, http.StatusMultiStatus, func(body io.Reader) error {
+		return xml.NewDecoder(body).Decode(&status)
+	}); err != nil {
+		return fmt.Errorf("failed to list %s: %w", u.Redacted(), err)
+	}
+
+	for _, response := range status.Responses {
+		href, err := u.Parse(response.Href)
+		if err != nil || !strings.HasPrefix(href.Path, s.root.Path) {
+			continue
+		}
+		name := strings.Trim(strings.TrimPrefix(href.Path, s.root.Path), "/")
+		if name == strings.TrimSuffix(dir, "/") || !fs.ValidPath(name) {
+			continue
+		}
+
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+
+			if prop.ResourceType.Collection != nil {
+				if s.ignore.Match(name, true) {
+					break
+				}
+				if err := s.list(ctx, name+"/", notes); err != nil {
+					return err
+				}
+				break
+			}
+			if !IsMarkdownFile(name) || s.ignore.Match(name, false) {
+				break
+			}
+
+			note := webDAVNote{etag: prop.ETag}
+			note.size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+			note.modTime, _ = http.ParseTime(prop.LastModified)
+			notes[name] = note
+			break
+		}
+	}
+
+	return nil
+}
+
+// get downloads the note at name
+func (s *WebDAVSource) get(ctx context.Context, name string) ([]byte, error) {
+	u := s.url(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if err := s.do(req, http.StatusOK, func(body io.Reader) error {
+		data, err = io.ReadAll(body)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	return data, nil
+}
+
+func (s *WebDAVSource) do(req *http.Request, expected int, read func(io.Reader) error) error {
+	if s.user != nil {
+		password, _ := s.user.Password()
+		req.SetBasicAuth(s.user.Username(), password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode != expected {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return read(resp.Body)
+}
+
+// url returns the URL of name, a slash-separated path relative to the root
+func (s *WebDAVSource) url(name string) *url.URL {
+	u := *s.root
+	u.Path = path.Join(u.Path, name)
+	if (name == "" || strings.HasSuffix(name, "/")) && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return &u
+}
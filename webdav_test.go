@@ -0,0 +1,131 @@
+package clippingsfeed_test
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"golang.org/x/net/webdav"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/poll"
+)
+
+// webDAVServer serves dir under /dav/ behind basic authentication, counting the downloads
+type webDAVServer struct {
+	mu        sync.Mutex
+	downloads map[string]int
+}
+
+func newWebDAVServer(t *testing.T, dir string) (*webDAVServer, *httptest.Server) {
+	t.Helper()
+
+	s := &webDAVServer{downloads: make(map[string]int)}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "vault" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodGet {
+			s.mu.Lock()
+			s.downloads[strings.TrimPrefix(r.URL.Path, "/dav/")]++
+			s.mu.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *webDAVServer) downloaded(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloads[name]
+}
+
+func TestWebDAVSource(t *testing.T) {
+	dir := t.TempDir()
+	writeNote := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NilError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NilError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	writeNote("vault/Clippings/first note.md", "---\ntitle: First\n---\nfirst\n")
+	writeNote("vault/Clippings/image.png", "not a note")
+	writeNote("vault/.obsidian/workspace.md", "ignored")
+	writeNote("vault/kept.md", "kept")
+	modTime := time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)
+	assert.NilError(t, os.Chtimes(filepath.Join(dir, "vault", "kept.md"), modTime, modTime))
+
+	dav, server := newWebDAVServer(t, dir)
+	u := strings.Replace(server.URL, "http://", "http://vault:secret@", 1) + "/dav/vault"
+
+	source, err := clippingsfeed.NewWebDAVSource(clippingsfeed.WebDAVConfig{
+		URL:          u,
+		PollInterval: 10 * time.Millisecond,
+		Ignore:       clippingsfeed.NewIgnoreMatcher(clippingsfeed.DefaultIgnorePatterns),
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, source.Sync(t.Context()))
+
+	assert.DeepEqual(t, readFS(t, source.FS()), map[string]string{
+		"Clippings/first note.md": "---\ntitle: First\n---\nfirst\n",
+		"kept.md":                 "kept",
+	})
+	info, err := fs.Stat(source.FS(), "kept.md")
+	assert.NilError(t, err)
+	assert.Assert(t, info.ModTime().Equal(modTime), "modified %v", info.ModTime())
+	assert.Equal(t, dav.downloaded("vault/.obsidian/workspace.md"), 0)
+
+	// Unchanged notes are not downloaded again
+	assert.NilError(t, source.Sync(t.Context()))
+	assert.Equal(t, dav.downloaded("vault/kept.md"), 1)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	recorder := &changeRecorder{}
+	done := make(chan error, 1)
+	go func() {
+		done <- source.Watch(ctx, recorder.notify)
+	}()
+
+	writeNote("vault/Clippings/second.md", "second")
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Clippings/second.md", Op: clippingsfeed.ChangeCreate}))
+
+	writeNote("vault/kept.md", "kept, then edited")
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "kept.md", Op: clippingsfeed.ChangeWrite}))
+
+	assert.NilError(t, os.Remove(filepath.Join(dir, "vault", "Clippings", "first note.md")))
+	poll.WaitOn(t, recorder.has(clippingsfeed.Change{Path: "Clippings/first note.md", Op: clippingsfeed.ChangeRemove}))
+
+	cancel()
+	assert.NilError(t, <-done)
+
+	assert.DeepEqual(t, readFS(t, source.FS()), map[string]string{
+		"Clippings/second.md": "second",
+		"kept.md":             "kept, then edited",
+	})
+}
+
+func TestWebDAVSourceErrors(t *testing.T) {
+	_, err := clippingsfeed.NewWebDAVSource(clippingsfeed.WebDAVConfig{URL: "ftp://example.com/vault"})
+	assert.ErrorContains(t, err, "scheme must be http or https")
+
+	_, server := newWebDAVServer(t, t.TempDir())
+	source, err := clippingsfeed.NewWebDAVSource(clippingsfeed.WebDAVConfig{URL: server.URL + "/dav/"})
+	assert.NilError(t, err)
+	assert.ErrorContains(t, source.Sync(t.Context()), "401 Unauthorized")
+}
@@ -0,0 +1,52 @@
+package clippingsfeed
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes a file through a temporary file in the same directory and renames it
+// into place, so readers only ever see the previous or the complete new content
+func WriteFileAtomic(filename string, write func(w io.Writer) error) error {
+	return WriteFileAtomicMode(filename, 0o644, write)
+}
+
+// WriteFileAtomicMode writes a file like WriteFileAtomic, giving it the permissions perm
+func WriteFileAtomicMode(filename string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	file, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %w", filename, err)
+	}
+	tmpName := file.Name()
+	defer func() {
+		if err != nil {
+			file.Close()       //nolint:errcheck,gosec
+			os.Remove(tmpName) //nolint:errcheck,gosec
+		}
+	}()
+
+	if err = write(file); err != nil {
+		return err
+	}
+	if err = file.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %w", tmpName, err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", tmpName, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpName, err)
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", tmpName, filename, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package clippingsfeed_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	clippingsfeed "github.com/nakatanakatana/obsidian-clippings-feed"
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "feed.rss")
+	assert.NilError(t, os.WriteFile(filename, []byte("old"), 0o644))
+
+	err := clippingsfeed.WriteFileAtomic(filename, func(w io.Writer) error {
+		// The previous content stays in place while the new one is written
+		content, err := os.ReadFile(filename)
+		assert.NilError(t, err)
+		assert.Equal(t, "old", string(content))
+
+		_, err = io.WriteString(w, "new")
+		return err
+	})
+	assert.NilError(t, err)
+
+	content, err := os.ReadFile(filename)
+	assert.NilError(t, err)
+	assert.Equal(t, "new", string(content))
+
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestWriteFileAtomicMode(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+
+	err := clippingsfeed.WriteFileAtomicMode(filename, 0o600, func(w io.Writer) error {
+		_, err := io.WriteString(w, "{}")
+		return err
+	})
+	assert.NilError(t, err)
+
+	info, err := os.Stat(filename)
+	assert.NilError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestWriteFileAtomicFailure(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "feed.rss")
+	assert.NilError(t, os.WriteFile(filename, []byte("old"), 0o644))
+
+	writeErr := errors.New("render failed")
+	err := clippingsfeed.WriteFileAtomic(filename, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "partial")
+		return writeErr
+	})
+	assert.ErrorIs(t, err, writeErr)
+
+	content, err := os.ReadFile(filename)
+	assert.NilError(t, err)
+	assert.Equal(t, "old", string(content))
+
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, 1, len(entries))
+}